@@ -1,16 +1,28 @@
 package command
 
-import "github.com/axetroy/s4/core/runner"
+import (
+	"errors"
+
+	"github.com/axetroy/s4/core/runner"
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+)
 
 // Default task
-func Default(configFile string) error {
-	r, err := runner.NewRunner(configFile)
+func Default(configFile string, options runner.Options) error {
+	r, err := runner.NewRunner(configFile, options)
 
 	if err != nil {
 		return err
 	}
 
 	if err := r.Run(); err != nil {
+		var interruptErr *runner.InterruptError
+
+		if errors.As(err, &interruptErr) {
+			return cli.Exit(color.YellowString(err.Error()), runner.InterruptExitCode)
+		}
+
 		return err
 	}
 
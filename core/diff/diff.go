@@ -0,0 +1,274 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	KindEqual  = ' '
+	KindDelete = '-'
+	KindInsert = '+'
+)
+
+// Line is a line of the diff result
+type Line struct {
+	Kind byte   // KindEqual, KindDelete or KindInsert
+	Text string // the content of line without line break
+}
+
+// split the text into lines. the last empty line is ignored
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// Lines compare the two texts line by line with Myers's algorithm in linear space
+func Lines(a string, b string) []Line {
+	return diff(splitLines(a), splitLines(b), nil)
+}
+
+// append the diff of a and b to lines. it is split at the middle snake and each half is compared recursively,
+// so only the furthest reaching paths of current round are kept in memory
+func diff(a []string, b []string, lines []Line) []Line {
+	// the common prefix and suffix
+	prefix := 0
+
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		lines = append(lines, Line{Kind: KindEqual, Text: a[prefix]})
+		prefix++
+	}
+
+	a, b = a[prefix:], b[prefix:]
+
+	suffix := 0
+
+	for suffix < len(a) && suffix < len(b) && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	tail := a[len(a)-suffix:]
+	a, b = a[:len(a)-suffix], b[:len(b)-suffix]
+
+	switch {
+	case len(a) == 0:
+		for _, text := range b {
+			lines = append(lines, Line{Kind: KindInsert, Text: text})
+		}
+	case len(b) == 0:
+		for _, text := range a {
+			lines = append(lines, Line{Kind: KindDelete, Text: text})
+		}
+	default:
+		// the edit distance is at least 2 here, so both halves are smaller
+		x, y, u, v := middleSnake(a, b)
+
+		lines = diff(a[:x], b[:y], lines)
+
+		for _, text := range a[x:u] {
+			lines = append(lines, Line{Kind: KindEqual, Text: text})
+		}
+
+		lines = diff(a[u:], b[v:], lines)
+	}
+
+	for _, text := range tail {
+		lines = append(lines, Line{Kind: KindEqual, Text: text})
+	}
+
+	return lines
+}
+
+// find the snake in the middle of the shortest edit script by searching from both ends.
+// the snake is from (x, y) to (u, v). a and b must not be empty
+func middleSnake(a []string, b []string) (x int, y int, u int, v int) {
+	n, m := len(a), len(b)
+	delta := n - m
+	odd := delta%2 != 0
+	max := (n + m + 1) / 2
+
+	// forward[k] is the furthest x on diagonal k from the start. backward[c] is the furthest x from the end
+	// on diagonal c of the reversed texts, which is diagonal delta-c of the texts. offset them to make the index positive
+	offset := max + 1
+	forward := make([]int, 2*max+3)
+	backward := make([]int, 2*max+3)
+
+	for d := 0; d <= max; d++ {
+		for k := -d; k <= d; k += 2 {
+			if k == -d || (k != d && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1] // move down. insert
+			} else {
+				x = forward[offset+k-1] + 1 // move right. delete
+			}
+
+			y = x - k
+			u, v = x, y
+
+			for u < n && v < m && a[u] == b[v] {
+				u++
+				v++
+			}
+
+			forward[offset+k] = u
+
+			// overlap the backward paths of last round
+			if c := delta - k; odd && c >= -(d-1) && c <= d-1 && u+backward[offset+c] >= n {
+				return x, y, u, v
+			}
+		}
+
+		for c := -d; c <= d; c += 2 {
+			var rx int
+
+			if c == -d || (c != d && backward[offset+c-1] < backward[offset+c+1]) {
+				rx = backward[offset+c+1]
+			} else {
+				rx = backward[offset+c-1] + 1
+			}
+
+			ry := rx - c
+			ru, rv := rx, ry
+
+			for ru < n && rv < m && a[n-1-ru] == b[m-1-rv] {
+				ru++
+				rv++
+			}
+
+			backward[offset+c] = ru
+
+			// overlap the forward paths of this round
+			if k := delta - c; !odd && k >= -d && k <= d && ru+forward[offset+k] >= n {
+				return n - ru, m - rv, n - rx, m - ry
+			}
+		}
+	}
+
+	// unreachable, the paths always overlap within max rounds. split after a to make sure the recursion ends
+	return n, 0, n, 0
+}
+
+// Hunk is a group of changed lines with the context
+type Hunk struct {
+	OldStart int // the line number of old text. start from 1
+	OldLines int
+	NewStart int // the line number of new text. start from 1
+	NewLines int
+	Lines    []Line
+}
+
+// Header of hunk. eg. @@ -1,3 +1,4 @@
+func (h Hunk) Header() string {
+	return fmt.Sprintf("@@ -%d,%d +%d,%d @@", h.OldStart, h.OldLines, h.NewStart, h.NewLines)
+}
+
+// Hunks group the changed lines with the given number of context lines
+func Hunks(lines []Line, context int) []Hunk {
+	var (
+		hunks   []Hunk
+		current *Hunk
+		oldLine = 1
+		newLine = 1
+		equals  = 0 // the number of equal lines at the end of current hunk
+	)
+
+	for index, line := range lines {
+		if line.Kind == KindEqual {
+			if current != nil {
+				// close the hunk if no change in the following context
+				if equals >= context && !hasChange(lines[index:], context+1) {
+					hunks = append(hunks, *current)
+					current = nil
+				} else {
+					current.Lines = append(current.Lines, line)
+					current.OldLines++
+					current.NewLines++
+					equals++
+				}
+			}
+
+			oldLine++
+			newLine++
+			continue
+		}
+
+		if current == nil {
+			// start a new hunk with the previous context
+			start := index - context
+
+			if start < 0 {
+				start = 0
+			}
+
+			current = &Hunk{
+				OldStart: oldLine - (index - start),
+				NewStart: newLine - (index - start),
+			}
+
+			for _, l := range lines[start:index] {
+				current.Lines = append(current.Lines, l)
+				current.OldLines++
+				current.NewLines++
+			}
+		}
+
+		current.Lines = append(current.Lines, line)
+		equals = 0
+
+		if line.Kind == KindDelete {
+			current.OldLines++
+			oldLine++
+		} else {
+			current.NewLines++
+			newLine++
+		}
+	}
+
+	if current != nil {
+		hunks = append(hunks, *current)
+	}
+
+	return hunks
+}
+
+// whether there is change in the first n lines
+func hasChange(lines []Line, n int) bool {
+	for index, line := range lines {
+		if index >= n {
+			break
+		}
+
+		if line.Kind != KindEqual {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Unified return the diff in unified format. empty string means no difference
+func Unified(oldName string, newName string, a string, b string, context int) string {
+	hunks := Hunks(Lines(a, b), context)
+
+	if len(hunks) == 0 {
+		return ""
+	}
+
+	var builder strings.Builder
+
+	builder.WriteString("--- " + oldName + "\n")
+	builder.WriteString("+++ " + newName + "\n")
+
+	for _, hunk := range hunks {
+		builder.WriteString(hunk.Header() + "\n")
+
+		for _, line := range hunk.Lines {
+			builder.WriteByte(line.Kind)
+			builder.WriteString(line.Text + "\n")
+		}
+	}
+
+	return builder.String()
+}
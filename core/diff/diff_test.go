@@ -0,0 +1,235 @@
+package diff_test
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/axetroy/s4/core/diff"
+)
+
+func TestLines(t *testing.T) {
+	type args struct {
+		a string
+		b string
+	}
+	tests := []struct {
+		name string
+		args args
+		want []diff.Line
+	}{
+		{
+			name: "empty",
+			args: args{a: "", b: ""},
+			want: nil,
+		},
+		{
+			name: "equal",
+			args: args{a: "a\nb\n", b: "a\nb\n"},
+			want: []diff.Line{
+				{Kind: diff.KindEqual, Text: "a"},
+				{Kind: diff.KindEqual, Text: "b"},
+			},
+		},
+		{
+			name: "new file",
+			args: args{a: "", b: "a\nb"},
+			want: []diff.Line{
+				{Kind: diff.KindInsert, Text: "a"},
+				{Kind: diff.KindInsert, Text: "b"},
+			},
+		},
+		{
+			name: "remove file",
+			args: args{a: "a\nb", b: ""},
+			want: []diff.Line{
+				{Kind: diff.KindDelete, Text: "a"},
+				{Kind: diff.KindDelete, Text: "b"},
+			},
+		},
+		{
+			name: "replace",
+			args: args{a: "a\nb\nc\n", b: "a\nB\nc\n"},
+			want: []diff.Line{
+				{Kind: diff.KindEqual, Text: "a"},
+				{Kind: diff.KindDelete, Text: "b"},
+				{Kind: diff.KindInsert, Text: "B"},
+				{Kind: diff.KindEqual, Text: "c"},
+			},
+		},
+		{
+			name: "insert and delete",
+			args: args{a: "a\nb\nc\nd\n", b: "b\nc\nx\nd\ne\n"},
+			want: []diff.Line{
+				{Kind: diff.KindDelete, Text: "a"},
+				{Kind: diff.KindEqual, Text: "b"},
+				{Kind: diff.KindEqual, Text: "c"},
+				{Kind: diff.KindInsert, Text: "x"},
+				{Kind: diff.KindEqual, Text: "d"},
+				{Kind: diff.KindInsert, Text: "e"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diff.Lines(tt.args.a, tt.args.b); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Lines() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnified(t *testing.T) {
+	var old []string
+
+	for i := 1; i <= 20; i++ {
+		old = append(old, strings.Repeat("x", i))
+	}
+
+	changed := append([]string{}, old...)
+	changed[1] = "changed 2"
+	changed[17] = "changed 18"
+
+	type args struct {
+		a       string
+		b       string
+		context int
+	}
+	tests := []struct {
+		name string
+		args args
+		want string
+	}{
+		{
+			name: "no difference",
+			args: args{a: "a\n", b: "a\n", context: 3},
+			want: "",
+		},
+		{
+			name: "two hunks",
+			args: args{a: strings.Join(old, "\n"), b: strings.Join(changed, "\n"), context: 1},
+			want: `--- old
++++ new
+@@ -1,3 +1,3 @@
+ x
+-xx
++changed 2
+ xxx
+@@ -17,3 +17,3 @@
+ xxxxxxxxxxxxxxxxx
+-xxxxxxxxxxxxxxxxxx
++changed 18
+ xxxxxxxxxxxxxxxxxxx
+`,
+		},
+		{
+			name: "merge hunks",
+			args: args{a: "1\n2\n3\n4\n5\n", b: "0\n2\n3\n4\n6\n", context: 2},
+			want: `--- old
++++ new
+@@ -1,5 +1,5 @@
+-1
++0
+ 2
+ 3
+ 4
+-5
++6
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diff.Unified("old", "new", tt.args.a, tt.args.b, tt.args.context); got != tt.want {
+				t.Errorf("Unified() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// rebuild the two texts from the diff result
+func applyLines(lines []diff.Line) (a []string, b []string, changes int) {
+	for _, line := range lines {
+		switch line.Kind {
+		case diff.KindEqual:
+			a = append(a, line.Text)
+			b = append(b, line.Text)
+		case diff.KindDelete:
+			a = append(a, line.Text)
+			changes++
+		case diff.KindInsert:
+			b = append(b, line.Text)
+			changes++
+		}
+	}
+
+	return a, b, changes
+}
+
+// the length of the longest common subsequence by dynamic programming
+func lcs(a []string, b []string) int {
+	dp := make([][]int, len(a)+1)
+
+	for i := range dp {
+		dp[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				dp[i][j] = dp[i+1][j+1] + 1
+			} else if dp[i+1][j] > dp[i][j+1] {
+				dp[i][j] = dp[i+1][j]
+			} else {
+				dp[i][j] = dp[i][j+1]
+			}
+		}
+	}
+
+	return dp[0][0]
+}
+
+func TestLinesShortest(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	randomLines := func() []string {
+		lines := make([]string, random.Intn(30))
+
+		for i := range lines {
+			lines[i] = string(rune('a' + random.Intn(4)))
+		}
+
+		return lines
+	}
+
+	for i := 0; i < 500; i++ {
+		a, b := randomLines(), randomLines()
+
+		gotA, gotB, changes := applyLines(diff.Lines(strings.Join(a, "\n"), strings.Join(b, "\n")))
+
+		if strings.Join(gotA, "\n") != strings.Join(a, "\n") || strings.Join(gotB, "\n") != strings.Join(b, "\n") {
+			t.Fatalf("Lines(%q, %q) does not rebuild the texts", a, b)
+		}
+
+		if want := len(a) + len(b) - 2*lcs(a, b); changes != want {
+			t.Fatalf("Lines(%q, %q) has %d changes, want %d", a, b, changes, want)
+		}
+	}
+}
+
+func TestLinesLarge(t *testing.T) {
+	var a, b []string
+
+	for i := 0; i < 4000; i++ {
+		a = append(a, fmt.Sprintf("old %d", i))
+		b = append(b, fmt.Sprintf("new %d", i))
+	}
+
+	_, _, changes := applyLines(diff.Lines(strings.Join(a, "\n"), strings.Join(b, "\n")))
+
+	if changes != 8000 {
+		t.Errorf("Lines() has %d changes, want %d", changes, 8000)
+	}
+}
@@ -0,0 +1,60 @@
+package edit
+
+import (
+	"regexp"
+	"strings"
+)
+
+// split the content into lines. the line break at the end is ignored
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
+
+// LineInFile ensure the line is in the content.
+// If match is not nil, the last line which matches it will be replaced with the line.
+// Otherwise the line will be appended if it does not exist.
+// It returns the new content and whether the content is changed
+func LineInFile(content string, line string, match *regexp.Regexp) (string, bool) {
+	lines := splitLines(content)
+
+	if match != nil {
+		for index := len(lines) - 1; index >= 0; index-- {
+			if !match.MatchString(lines[index]) {
+				continue
+			}
+
+			if lines[index] == line {
+				return content, false
+			}
+
+			lines[index] = line
+
+			return strings.Join(lines, "\n") + "\n", true
+		}
+	}
+
+	for _, l := range lines {
+		if l == line {
+			return content, false
+		}
+	}
+
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+
+	return content + line + "\n", true
+}
+
+// Replace all the text which matches the pattern with the replacement.
+// The replacement is literal, `$` in it is not expanded, as it may come from the variables.
+// It returns the new content and whether the content is changed
+func Replace(content string, pattern *regexp.Regexp, replacement string) (string, bool) {
+	result := pattern.ReplaceAllLiteralString(content, replacement)
+
+	return result, result != content
+}
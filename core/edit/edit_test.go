@@ -0,0 +1,132 @@
+package edit_test
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/axetroy/s4/core/edit"
+)
+
+func TestLineInFile(t *testing.T) {
+	type args struct {
+		content string
+		line    string
+		match   *regexp.Regexp
+	}
+	tests := []struct {
+		name        string
+		args        args
+		want        string
+		wantChanged bool
+	}{
+		{
+			name:        "empty file",
+			args:        args{content: "", line: "vm.swappiness=10"},
+			want:        "vm.swappiness=10\n",
+			wantChanged: true,
+		},
+		{
+			name:        "append",
+			args:        args{content: "a=1\n", line: "vm.swappiness=10"},
+			want:        "a=1\nvm.swappiness=10\n",
+			wantChanged: true,
+		},
+		{
+			name:        "append without line break at the end",
+			args:        args{content: "a=1", line: "vm.swappiness=10"},
+			want:        "a=1\nvm.swappiness=10\n",
+			wantChanged: true,
+		},
+		{
+			name:        "line exist",
+			args:        args{content: "vm.swappiness=10\na=1", line: "vm.swappiness=10"},
+			want:        "vm.swappiness=10\na=1",
+			wantChanged: false,
+		},
+		{
+			name:        "replace the matched line",
+			args:        args{content: "a=1\nvm.swappiness=60\nb=2\n", line: "vm.swappiness=10", match: regexp.MustCompile("^vm.swappiness")},
+			want:        "a=1\nvm.swappiness=10\nb=2\n",
+			wantChanged: true,
+		},
+		{
+			name:        "replace the last matched line",
+			args:        args{content: "vm.swappiness=60\nvm.swappiness=30\n", line: "vm.swappiness=10", match: regexp.MustCompile("^vm.swappiness")},
+			want:        "vm.swappiness=60\nvm.swappiness=10\n",
+			wantChanged: true,
+		},
+		{
+			name:        "matched line is the same",
+			args:        args{content: "a=1\nvm.swappiness=10\n", line: "vm.swappiness=10", match: regexp.MustCompile("^vm.swappiness")},
+			want:        "a=1\nvm.swappiness=10\n",
+			wantChanged: false,
+		},
+		{
+			name:        "append if not matched",
+			args:        args{content: "a=1\n", line: "vm.swappiness=10", match: regexp.MustCompile("^vm.swappiness")},
+			want:        "a=1\nvm.swappiness=10\n",
+			wantChanged: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changed := edit.LineInFile(tt.args.content, tt.args.line, tt.args.match)
+			if got != tt.want {
+				t.Errorf("LineInFile() got = %q, want %q", got, tt.want)
+			}
+			if changed != tt.wantChanged {
+				t.Errorf("LineInFile() changed = %v, want %v", changed, tt.wantChanged)
+			}
+		})
+	}
+}
+
+func TestReplace(t *testing.T) {
+	type args struct {
+		content     string
+		pattern     *regexp.Regexp
+		replacement string
+	}
+	tests := []struct {
+		name        string
+		args        args
+		want        string
+		wantChanged bool
+	}{
+		{
+			name:        "replace",
+			args:        args{content: "host: 0.0.0.0\nport: 80\n", pattern: regexp.MustCompile(`port: \d+`), replacement: "port: 8080"},
+			want:        "host: 0.0.0.0\nport: 8080\n",
+			wantChanged: true,
+		},
+		{
+			name:        "already replaced",
+			args:        args{content: "port: 8080\n", pattern: regexp.MustCompile(`port: \d+`), replacement: "port: 8080"},
+			want:        "port: 8080\n",
+			wantChanged: false,
+		},
+		{
+			name:        "not matched",
+			args:        args{content: "host: 0.0.0.0\n", pattern: regexp.MustCompile(`port: \d+`), replacement: "port: 8080"},
+			want:        "host: 0.0.0.0\n",
+			wantChanged: false,
+		},
+		{
+			name:        "literal replacement",
+			args:        args{content: "password: old\n", pattern: regexp.MustCompile(`(password): \w+`), replacement: "password: p$1w$$"},
+			want:        "password: p$1w$$\n",
+			wantChanged: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changed := edit.Replace(tt.args.content, tt.args.pattern, tt.args.replacement)
+			if got != tt.want {
+				t.Errorf("Replace() got = %q, want %q", got, tt.want)
+			}
+			if changed != tt.wantChanged {
+				t.Errorf("Replace() changed = %v, want %v", changed, tt.wantChanged)
+			}
+		})
+	}
+}
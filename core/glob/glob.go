@@ -0,0 +1,128 @@
+package glob
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// the pattern which matches zero or more dirs
+const globstar = "**"
+
+// HasMeta reports whether the pattern contains any of the magic characters
+func HasMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// Match reports whether the name matches the pattern. both of them are separated by `/`.
+// `**` matches zero or more dirs. the others are the same as path.Match
+func Match(pattern string, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern []string, name []string) bool {
+	for len(pattern) != 0 {
+		if pattern[0] == globstar {
+			pattern = pattern[1:]
+
+			// `dir/**` matches everything in the dir
+			if len(pattern) == 0 {
+				return len(name) != 0
+			}
+
+			for index := 0; index <= len(name); index++ {
+				if matchSegments(pattern, name[index:]) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+
+		if matched, err := path.Match(pattern[0], name[0]); err != nil || !matched {
+			return false
+		}
+
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+
+	return len(name) == 0
+}
+
+// Base returns the leading dirs of the pattern which have no magic characters
+func Base(pattern string) string {
+	segments := strings.Split(pattern, "/")
+
+	for index, segment := range segments {
+		if HasMeta(segment) {
+			segments = segments[:index]
+			break
+		}
+	}
+
+	base := strings.Join(segments, "/")
+
+	if base == "" && strings.HasPrefix(pattern, "/") {
+		return "/"
+	}
+
+	if base == "" {
+		return "."
+	}
+
+	return base
+}
+
+// Rel returns the path of name relative to the base of pattern.
+// it is used to keep the structure of dirs when transferring the matched files
+func Rel(pattern string, name string) string {
+	base := Base(pattern)
+
+	if base == "." {
+		return name
+	}
+
+	return strings.TrimPrefix(strings.TrimPrefix(name, base), "/")
+}
+
+// Glob returns the local files which match the pattern.
+// the pattern is returned as it is if it has no magic characters
+func Glob(pattern string) ([]string, error) {
+	if !HasMeta(pattern) {
+		return []string{pattern}, nil
+	}
+
+	if !strings.Contains(pattern, globstar) {
+		return filepath.Glob(pattern)
+	}
+
+	pattern = filepath.ToSlash(pattern)
+	root := filepath.FromSlash(Base(pattern))
+
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	var matches []string
+
+	err := filepath.Walk(root, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// the dirs are not returned, otherwise the files in it will be duplicated
+		if !info.IsDir() && Match(pattern, filepath.ToSlash(filePath)) {
+			matches = append(matches, filePath)
+		}
+
+		return nil
+	})
+
+	return matches, err
+}
@@ -0,0 +1,126 @@
+package glob_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/axetroy/s4/core/glob"
+)
+
+func TestMatch(t *testing.T) {
+	type args struct {
+		pattern string
+		name    string
+	}
+	tests := []struct {
+		name string
+		args args
+		want bool
+	}{
+		{name: "literal", args: args{pattern: "dist/app.js", name: "dist/app.js"}, want: true},
+		{name: "star", args: args{pattern: "dist/*.js", name: "dist/app.js"}, want: true},
+		{name: "star does not match dir", args: args{pattern: "dist/*.js", name: "dist/js/app.js"}, want: false},
+		{name: "globstar", args: args{pattern: "dist/**/*.js", name: "dist/js/vendor/app.js"}, want: true},
+		{name: "globstar matches zero dir", args: args{pattern: "dist/**/*.js", name: "dist/app.js"}, want: true},
+		{name: "globstar at the end", args: args{pattern: "dist/**", name: "dist/js/app.js"}, want: true},
+		{name: "globstar at the end does not match the dir itself", args: args{pattern: "dist/**", name: "dist"}, want: false},
+		{name: "globstar at the begin", args: args{pattern: "**/node_modules", name: "a/b/node_modules"}, want: true},
+		{name: "absolute", args: args{pattern: "/var/log/**/*.log", name: "/var/log/nginx/error.log"}, want: true},
+		{name: "question mark", args: args{pattern: "app.?s", name: "app.js"}, want: true},
+		{name: "character class", args: args{pattern: "app.[jt]s", name: "app.ts"}, want: true},
+		{name: "not match", args: args{pattern: "dist/**/*.css", name: "dist/js/app.js"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := glob.Match(tt.args.pattern, tt.args.name); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBase(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{pattern: "dist/**/*.js", want: "dist"},
+		{pattern: "/srv/app/*/current", want: "/srv/app"},
+		{pattern: "/*.log", want: "/"},
+		{pattern: "*.log", want: "."},
+		{pattern: "dist/app.js", want: "dist/app.js"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			if got := glob.Base(tt.pattern); got != tt.want {
+				t.Errorf("Base() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRel(t *testing.T) {
+	if got := glob.Rel("/srv/dist/**/*.js", "/srv/dist/js/app.js"); got != "js/app.js" {
+		t.Errorf("Rel() = %v, want %v", got, "js/app.js")
+	}
+
+	if got := glob.Rel("*.js", "app.js"); got != "app.js" {
+		t.Errorf("Rel() = %v, want %v", got, "app.js")
+	}
+}
+
+func TestGlob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s4_glob_")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"app.js", "app.js.map", "js/vendor.js", "js/lib/util.js", "css/style.css"} {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(file, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{pattern: "*.js", want: []string{"app.js"}},
+		{pattern: "**/*.js", want: []string{"app.js", "js/lib/util.js", "js/vendor.js"}},
+		{pattern: "js/**", want: []string{"js/lib/util.js", "js/vendor.js"}},
+		{pattern: "not-exist/**/*.js", want: nil},
+		{pattern: "css/style.css", want: []string{"css/style.css"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			got, err := glob.Glob(filepath.Join(dir, filepath.FromSlash(tt.pattern)))
+
+			if err != nil {
+				t.Errorf("Glob() error = %v", err)
+				return
+			}
+
+			var want []string
+
+			for _, name := range tt.want {
+				want = append(want, filepath.Join(dir, filepath.FromSlash(name)))
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Glob() = %v, want %v", got, want)
+			}
+		})
+	}
+}
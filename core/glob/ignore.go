@@ -0,0 +1,127 @@
+package glob
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// IgnoreFile is the file which lists the files to skip when uploading the dir
+const IgnoreFile = ".s4ignore"
+
+type rule struct {
+	pattern  string
+	negate   bool // the rule starts with `!`
+	dirOnly  bool // the rule ends with `/`
+	anchored bool // the rule contains `/`. it matches the path relative to the root instead of the name
+}
+
+// Ignore is a list of rules in gitignore syntax
+type Ignore struct {
+	rules []rule
+}
+
+func NewIgnore(patterns []string) *Ignore {
+	ignore := &Ignore{}
+
+	ignore.Add(patterns...)
+
+	return ignore
+}
+
+// Add the rules. the blank line and the line starts with `#` are skipped
+func (i *Ignore) Add(patterns ...string) {
+	for _, pattern := range patterns {
+		pattern = strings.TrimRight(pattern, " \t\r")
+
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+
+		r := rule{}
+
+		if strings.HasPrefix(pattern, "!") {
+			r.negate = true
+			pattern = pattern[1:]
+		} else if strings.HasPrefix(pattern, `\#`) || strings.HasPrefix(pattern, `\!`) {
+			pattern = pattern[1:]
+		}
+
+		if strings.HasSuffix(pattern, "/") {
+			r.dirOnly = true
+			pattern = strings.TrimRight(pattern, "/")
+		}
+
+		if strings.Contains(pattern, "/") {
+			r.anchored = true
+			pattern = strings.TrimPrefix(pattern, "/")
+		}
+
+		if pattern == "" {
+			continue
+		}
+
+		r.pattern = pattern
+
+		i.rules = append(i.rules, r)
+	}
+}
+
+// Match reports whether the file should be skipped. the name is the path relative to the root, separated by `/`.
+// the file in a skipped dir is skipped too
+func (i *Ignore) Match(name string, isDir bool) bool {
+	if i == nil || len(i.rules) == 0 {
+		return false
+	}
+
+	segments := strings.Split(name, "/")
+
+	for index := 1; index < len(segments); index++ {
+		if i.match(strings.Join(segments[:index], "/"), true) {
+			return true
+		}
+	}
+
+	return i.match(name, isDir)
+}
+
+// the last matched rule wins
+func (i *Ignore) match(name string, isDir bool) bool {
+	ignored := false
+	base := name[strings.LastIndex(name, "/")+1:]
+
+	for _, r := range i.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+
+		if r.anchored && Match(r.pattern, name) || !r.anchored && Match(r.pattern, base) {
+			ignored = !r.negate
+		}
+	}
+
+	return ignored
+}
+
+// ReadIgnoreFile returns the lines of file. it returns nil if the file does not exist
+func ReadIgnoreFile(filepath string) ([]string, error) {
+	file, err := os.Open(filepath)
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	var lines []string
+
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	return lines, scanner.Err()
+}
@@ -0,0 +1,60 @@
+package glob_test
+
+import (
+	"testing"
+
+	"github.com/axetroy/s4/core/glob"
+)
+
+func TestIgnore_Match(t *testing.T) {
+	ignore := glob.NewIgnore([]string{
+		"# comment",
+		"",
+		"node_modules",
+		".git",
+		"*.map",
+		"!keep.map",
+		"/build",
+		"logs/",
+		"docs/**/*.md",
+		`\#hash`,
+	})
+
+	type args struct {
+		name  string
+		isDir bool
+	}
+	tests := []struct {
+		name string
+		args args
+		want bool
+	}{
+		{name: "name at root", args: args{name: "node_modules", isDir: true}, want: true},
+		{name: "name in sub dir", args: args{name: "packages/a/node_modules", isDir: true}, want: true},
+		{name: "file in ignored dir", args: args{name: "node_modules/lodash/index.js"}, want: true},
+		{name: "wildcard", args: args{name: "js/app.js.map"}, want: true},
+		{name: "negate", args: args{name: "js/keep.map"}, want: false},
+		{name: "anchored", args: args{name: "build", isDir: true}, want: true},
+		{name: "anchored does not match sub dir", args: args{name: "src/build", isDir: true}, want: false},
+		{name: "dir only", args: args{name: "logs", isDir: true}, want: true},
+		{name: "dir only does not match file", args: args{name: "logs"}, want: false},
+		{name: "globstar", args: args{name: "docs/api/v1/index.md"}, want: true},
+		{name: "escaped", args: args{name: "#hash"}, want: true},
+		{name: "not ignored", args: args{name: "js/app.js"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ignore.Match(tt.args.name, tt.args.isDir); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIgnore_MatchNil(t *testing.T) {
+	var ignore *glob.Ignore
+
+	if ignore.Match("node_modules", true) {
+		t.Errorf("Match() of nil should be false")
+	}
+}
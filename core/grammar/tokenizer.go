@@ -2,8 +2,11 @@ package grammar
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/axetroy/s4/core/host"
@@ -16,9 +19,37 @@ type Token struct {
 }
 
 type NodeUpload struct {
-	SourceFiles    []string
-	DestinationDir string
-	SourceCode     string
+	SourceFiles     []string
+	DestinationDir  string
+	DestinationFile string      // the destination file path with `AS`. DestinationDir is empty if it is set
+	Exclude         []string    // the files to skip in gitignore syntax
+	Archive         bool        // transfer the files as one tar.gz stream
+	Verify          bool        // compare the SHA-256 of the transferred files with the source files
+	Parallel        int         // the number of files to transfer concurrently. zero means the default
+	Preserve        []string    // the attributes to keep. eg. ["times", "owner", "links"]
+	Mode            os.FileMode // the mode of the transferred files instead of the source ones. zero means not set
+	IfNewer         bool        // skip the files which are not newer than the destination
+	NoClobber       bool        // skip the files which exist in the destination
+	Backup          bool        // rename the existing destination file to name.<timestamp>.bak before overwriting
+	Sudo            bool        // upload with sudo
+	SourceCode      string
+}
+
+type NodeSync struct {
+	Source      string // the local dir. it is the remote dir if Remote is true
+	Destination string // the remote dir. it is the local dir if Remote is true
+	Remote      bool   // sync the remote dir to local
+	Delete      bool   // remove the files in destination which do not exist in source
+	Checksum    bool   // compare the files by SHA-256 instead of size and modification time
+	Append      bool   // download the appended part of growing files only
+	SourceCode  string
+}
+
+type NodeChecksum struct {
+	Path       string
+	Algorithm  string // the hash algorithm. only SHA256 is supported
+	Expected   string // the expected checksum in hex
+	SourceCode string
 }
 
 type NodeConnect struct {
@@ -33,6 +64,7 @@ type NodeConnect struct {
 type NodeEnv struct {
 	Key        string
 	Value      string
+	Local      bool // set the environment variable for local commands
 	SourceCode string
 }
 
@@ -66,36 +98,195 @@ type NodeCopy struct {
 
 type NodeRun struct {
 	Commands   []NodeRunCommand
+	Idempotent bool   // whether the command can be retried after the connection dropped
+	Tty        bool   // whether the command need a terminal
+	Sudo       bool   // run the command with sudo
+	AllowFail  bool   // do not abort the workflow if the command exit with non-zero code
+	Register   string // the variable name to register the result of command
 	SourceCode string
 }
 
 type NodeRunCommand struct {
 	Command    []string
 	RunInLocal bool
+	Output     string // redirect the stdout of local command to the file
+	Shell      bool   // run the source code with local shell. eg. `LOCAL RUN npm run build && npm test`
 	SourceCode string
 }
 
 type NodeDelete struct {
+	Targets    []string
+	Exclude    []string // the files to skip in gitignore syntax
+	SourceCode string
+}
+
+type NodeMkdir struct {
+	Targets    []string
+	Parents    bool // create the parent dirs if not exist. eg. `MKDIR -p dir`
+	SourceCode string
+}
+
+type NodeChmod struct {
+	Mode       os.FileMode
+	Target     string
+	Recursive  bool
+	SourceCode string
+}
+
+type NodeChown struct {
+	Owner      string // the name or id of user. empty means not change
+	Group      string // the name or id of group. empty means not change
+	Target     string
+	Recursive  bool
+	SourceCode string
+}
+
+type NodeLink struct {
+	Target     string // the file which the link point to
+	Name       string // the path of link
+	SourceCode string
+}
+
+type NodeTemplate struct {
+	Source      string      // the local template file
+	Destination string      // the remote file
+	Mode        os.FileMode // the mode of remote file. zero means not set
+	SourceCode  string
+}
+
+type NodeWrite struct {
+	Path       string
+	Content    string
+	Mode       os.FileMode // the mode of remote file. zero means not set
+	SourceCode string
+}
+
+type NodeRead struct {
+	Key        string // the variable name
+	Path       string
+	SourceCode string
+}
+
+type NodeLineInFile struct {
+	Path       string
+	Line       string
+	Match      string // the regular expression to find the line to replace. empty means not set
+	SourceCode string
+}
+
+type NodeReplace struct {
+	Path        string
+	Pattern     string // the regular expression
+	Replacement string
+	SourceCode  string
+}
+
+type NodeTouch struct {
 	Targets    []string
 	SourceCode string
 }
 
 type NodeCd struct {
 	Target     string
+	Local      bool // change the working directory of local machine
+	SourceCode string
+}
+
+type NodePopd struct {
+	Local bool // pop the directory stack of local machine
+}
+
+type NodeTunnel struct {
+	Remote     bool   // forward remote port to local or local port to remote
+	Port       string // the port to listen
+	Target     string // the address to forward to
 	SourceCode string
 }
 
+type NodeBecome struct {
+	User       string
+	SourceCode string
+}
+
+type NodeShell struct {
+	Shell      []string // the shell and its arguments to wrap the remote command. eg. ["bash", "-lc"]
+	Local      bool     // set the shell for local commands
+	SourceCode string
+}
+
+type NodeEnd struct {
+	Action string // the action of block to close. eg. `TUNNEL`, `LOCAL SHELL`. empty means the last opened block
+}
+
+const (
+	FlagIDEMPOTENT = "IDEMPOTENT"
+	FlagTTY        = "TTY"
+	FlagALLOWFAIL  = "ALLOW_FAIL"
+	FlagREGISTER   = "REGISTER"
+	FlagRECURSIVE  = "RECURSIVE"
+	FlagPARENTS    = "-p"
+	FlagMODE       = "MODE"
+	FlagMATCH      = "MATCH"
+	FlagDELETE     = "DELETE"
+	FlagCHECKSUM   = "CHECKSUM"
+	FlagAPPEND     = "APPEND"
+	FlagREMOTE     = "REMOTE"
+	FlagEXCLUDE    = "EXCLUDE"
+	FlagARCHIVE    = "ARCHIVE"
+	FlagVERIFY     = "VERIFY"
+	FlagPARALLEL   = "PARALLEL"
+	FlagPRESERVE   = "PRESERVE"
+	FlagAS         = "AS"
+	FlagIFNEWER    = "IF_NEWER"
+	FlagNOCLOBBER  = "NO_CLOBBER"
+	FlagBACKUP     = "BACKUP"
+)
+
+// the hash algorithms of `CHECKSUM`
+const (
+	ChecksumSHA256 = "SHA256"
+)
+
+// the attributes of `PRESERVE`
 const (
-	ActionCONNECT  = "CONNECT"
-	ActionENV      = "ENV"
-	ActionVAR      = "VAR"
-	ActionCD       = "CD"
-	ActionUPLOAD   = "UPLOAD"
-	ActionDOWNLOAD = "DOWNLOAD"
-	ActionCOPY     = "COPY"
-	ActionMOVE     = "MOVE"
-	ActionDELETE   = "DELETE"
-	ActionRUN      = "RUN"
+	PreserveTIMES = "times"
+	PreserveOWNER = "owner"
+	PreserveLINKS = "links"
+)
+
+const (
+	ActionCONNECT    = "CONNECT"
+	ActionENV        = "ENV"
+	ActionVAR        = "VAR"
+	ActionCD         = "CD"
+	ActionLCD        = "LCD"
+	ActionPUSHD      = "PUSHD"
+	ActionPOPD       = "POPD"
+	ActionUPLOAD     = "UPLOAD"
+	ActionDOWNLOAD   = "DOWNLOAD"
+	ActionSYNC       = "SYNC"
+	ActionCHECKSUM   = "CHECKSUM"
+	ActionCOPY       = "COPY"
+	ActionMOVE       = "MOVE"
+	ActionDELETE     = "DELETE"
+	ActionMKDIR      = "MKDIR"
+	ActionCHMOD      = "CHMOD"
+	ActionCHOWN      = "CHOWN"
+	ActionLINK       = "LINK"
+	ActionTOUCH      = "TOUCH"
+	ActionTEMPLATE   = "TEMPLATE"
+	ActionWRITE      = "WRITE"
+	ActionAPPEND     = "APPEND"
+	ActionREAD       = "READ"
+	ActionLINEINFILE = "LINEINFILE"
+	ActionREPLACE    = "REPLACE"
+	ActionRUN        = "RUN"
+	ActionTUNNEL     = "TUNNEL"
+	ActionSUDO       = "SUDO"
+	ActionBECOME     = "BECOME"
+	ActionSHELL      = "SHELL"
+	ActionLOCAL      = "LOCAL"
+	ActionEND        = "END"
 )
 
 var (
@@ -104,13 +295,35 @@ var (
 		ActionENV,
 		ActionVAR,
 		ActionCD,
+		ActionLCD,
+		ActionPUSHD,
+		ActionPOPD,
 		ActionUPLOAD,
 		ActionDOWNLOAD,
+		ActionSYNC,
+		ActionCHECKSUM,
 		ActionCOPY,
 		ActionMOVE,
 		ActionDELETE,
+		ActionMKDIR,
+		ActionCHMOD,
+		ActionCHOWN,
+		ActionLINK,
+		ActionTOUCH,
+		ActionTEMPLATE,
+		ActionWRITE,
+		ActionAPPEND,
+		ActionREAD,
+		ActionLINEINFILE,
+		ActionREPLACE,
 		ActionRUN,
 		ActionRUN,
+		ActionTUNNEL,
+		ActionSUDO,
+		ActionBECOME,
+		ActionSHELL,
+		ActionLOCAL,
+		ActionEND,
 	}
 	commentIdentifier = "#"
 	validKeywordReg   = regexp.MustCompile(strings.Join(Actions, "|"))
@@ -119,10 +332,41 @@ var (
 	lineWrapReg       = regexp.MustCompile("\\\r?\\\n")
 	lineBreakChar     = "\\"
 	spaceBlank        = " "
+	pipeOperator      = "|>"
+	envReg            = regexp.MustCompile("^([A-Za-z_]\\w*)\\s*=\\s*(\\S.*)$")
+	registerReg       = regexp.MustCompile("\\s+" + FlagREGISTER + "\\s+(\\w+)\\s*$")
+	fileModeReg       = regexp.MustCompile("^0?[0-7]{3,4}$")
+	ownerReg          = regexp.MustCompile("^([\\w.-]*)(?::([\\w.-]*))?$")
+	heredocReg        = regexp.MustCompile("(?:^|\\s)<<(\\w+)$")
+	writeReg          = regexp.MustCompile("^(\\S+)(?:\\s+" + FlagMODE + "\\s+(\\S+))?(?:\\s+(.*))?$")
+	lineInFileReg     = regexp.MustCompile(`^(\S+)\s+("[^"]*"|'[^']*'|\S+)(?:\s+` + FlagMATCH + `\s+(.+))?$`)
+	replaceReg        = regexp.MustCompile(`^(\S+)\s+/((?:[^/\\]|\\.)*)/\s+(.+)$`)
+	readReg           = regexp.MustCompile("^(\\w+)\\s*=\\s*(\\S+)$")
+	tunnelReg         = regexp.MustCompile("^(LOCAL|REMOTE)\\s+(\\d+)\\s+TO\\s+(\\S+:\\d+)$")
 )
 
 func isAllowLineBreakAction(actionName string) bool {
-	return actionName == ActionRUN
+	return actionName == ActionRUN || actionName == ActionSUDO || actionName == ActionLOCAL
+}
+
+// action which use the raw value. `#` is not a comment and the spaces are kept
+func isRawValueAction(actionName string) bool {
+	return isHeredocAction(actionName) || actionName == ActionLINEINFILE || actionName == ActionREPLACE
+}
+
+// action which accepts heredoc. eg. <<EOF ... EOF
+func isHeredocAction(actionName string) bool {
+	return actionName == ActionWRITE || actionName == ActionAPPEND
+}
+
+// action which does not accept any value
+func isNoValueAction(actionName string) bool {
+	return actionName == ActionPOPD
+}
+
+// action which accepts an optional value
+func isOptionalValueAction(actionName string) bool {
+	return actionName == ActionEND
 }
 
 func Tokenizer(input string) ([]Token, error) {
@@ -201,13 +445,17 @@ func Tokenizer(input string) ([]Token, error) {
 					break
 				}
 				char = string(input[currentIndex])
-				if emptyStrReg.MatchString(char) {
+				// do not skip to next line. the action may not have value
+				if emptyStrReg.MatchString(char) && !lineWrapReg.MatchString(char) {
 					currentIndex++
 				} else {
 					break skipEmptyString
 				}
 			}
 
+			// the start of value. use for the action which need the raw value
+			valueStart := currentIndex
+
 		findValue:
 			for {
 				if currentIndex > len(input)-1 {
@@ -220,7 +468,7 @@ func Tokenizer(input string) ([]Token, error) {
 				if lineWrapReg.MatchString(char) {
 
 					// only allow RUN to use line break
-					if keyword == ActionRUN {
+					if isAllowLineBreakAction(keyword) {
 						// find space blank forward and skip it.
 						lastCharIndex := currentIndex - 1
 						lastChar := ""
@@ -247,7 +495,7 @@ func Tokenizer(input string) ([]Token, error) {
 				}
 
 				// if found comment, then ignore future content
-				if char == commentIdentifier {
+				if char == commentIdentifier && !isRawValueAction(keyword) {
 					break findValue
 				}
 
@@ -270,8 +518,31 @@ func Tokenizer(input string) ([]Token, error) {
 
 			currentValue = ""
 
-			// value must set
-			if len(value) == 0 {
+			var (
+				rawValue = strings.TrimSpace(input[valueStart:currentIndex])
+				heredoc  *string // the content of heredoc. eg. <<EOF ... EOF
+			)
+
+			if isHeredocAction(keyword) {
+				if matchers := heredocReg.FindStringSubmatch(rawValue); matchers != nil {
+					content, nextIndex, err := readHeredoc(input, currentIndex, matchers[1])
+
+					if err != nil {
+						return tokens, err
+					}
+
+					heredoc = &content
+					rawValue = strings.TrimSpace(heredocReg.ReplaceAllString(rawValue, ""))
+					currentIndex = nextIndex
+				}
+			}
+
+			if isNoValueAction(keyword) {
+				if len(value) != 0 {
+					return tokens, fmt.Errorf("`%s` does not accept any value but got `%s`", keyword, strings.Join(value, spaceBlank))
+				}
+			} else if len(value) == 0 && !isOptionalValueAction(keyword) {
+				// value must set
 				return tokens, fmt.Errorf("`%s` require value", keyword)
 			}
 
@@ -305,48 +576,177 @@ func Tokenizer(input string) ([]Token, error) {
 				}
 				break
 			case ActionENV:
-				if regexp.MustCompile("\\w+\\s?=\\s?\\w+").MatchString(valueStr) == false {
+				matchers := envReg.FindStringSubmatch(valueStr)
+
+				if matchers == nil {
 					return tokens, fmt.Errorf("`ENV` need to match `KEY = VALUE` format but got `%s`", valueStr)
 				}
 
 				tokens = append(tokens, Token{
 					Key: keyword,
 					Node: NodeEnv{
-						Key:        value[0],
-						Value:      value[2],
+						Key:        matchers[1],
+						Value:      unquote(matchers[2]),
 						SourceCode: valueStr,
 					},
 				})
 
 				break
-			case ActionCD:
+			case ActionCD, ActionLCD, ActionPUSHD:
 				if valueLength != 1 {
-					return tokens, fmt.Errorf("`CD` only accepts one string but got `%s`", valueStr)
+					return tokens, fmt.Errorf("`%s` only accepts one string but got `%s`", keyword, valueStr)
 				}
 				tokens = append(tokens, Token{
 					Key: keyword,
 					Node: NodeCd{
 						Target:     valueStr,
+						Local:      keyword == ActionLCD,
 						SourceCode: valueStr,
 					},
 				})
 				break
+			case ActionPOPD:
+				tokens = append(tokens, Token{
+					Key:  keyword,
+					Node: NodePopd{},
+				})
+				break
 			case ActionUPLOAD:
 				fallthrough
 			case ActionDOWNLOAD:
-				if valueLength < 2 {
+				value, exclude, err := cutExclude(value)
+
+				if err != nil {
+					return tokens, err
+				}
+
+				value, parallel, err := cutParallel(value)
+
+				if err != nil {
+					return tokens, err
+				}
+
+				value, preserve, err := cutPreserve(value)
+
+				if err != nil {
+					return tokens, err
+				}
+
+				value, modeStr, err := cutValueOption(value, FlagMODE)
+
+				if err != nil {
+					return tokens, err
+				}
+
+				var mode os.FileMode
+
+				if modeStr != "" {
+					if mode, err = parseFileMode(modeStr); err != nil {
+						return tokens, err
+					}
+				}
+
+				value, flags := cutValueFlags(value, FlagARCHIVE, FlagVERIFY, FlagIFNEWER, FlagNOCLOBBER, FlagBACKUP)
+
+				if len(value) < 2 {
 					return tokens, fmt.Errorf("`%s` only accepts one string but got `%s`", keyword, valueStr)
 				}
 
+				node := NodeUpload{
+					SourceFiles:    value[:len(value)-1],
+					DestinationDir: value[len(value)-1],
+					Exclude:        exclude,
+					Archive:        flags[FlagARCHIVE],
+					Verify:         flags[FlagVERIFY],
+					Parallel:       parallel,
+					Preserve:       preserve,
+					Mode:           mode,
+					IfNewer:        flags[FlagIFNEWER],
+					NoClobber:      flags[FlagNOCLOBBER],
+					Backup:         flags[FlagBACKUP],
+					SourceCode:     valueStr,
+				}
+
+				// UPLOAD ./build/app-linux AS /usr/local/bin/app
+				if len(value) == 3 && value[1] == FlagAS {
+					node.SourceFiles = value[:1]
+					node.DestinationDir = ""
+					node.DestinationFile = value[2]
+				} else {
+					for _, word := range value {
+						if word == FlagAS {
+							return tokens, fmt.Errorf("`%s` only accepts one source with `%s` like `<source> %s <destination>` but got `%s`", keyword, FlagAS, FlagAS, valueStr)
+						}
+					}
+				}
+
+				if node.NoClobber && (node.IfNewer || node.Backup) {
+					return tokens, fmt.Errorf("`%s` does not support `%s` with `%s` or `%s`", keyword, FlagNOCLOBBER, FlagIFNEWER, FlagBACKUP)
+				}
+
+				if node.Archive && (node.DestinationFile != "" || node.IfNewer || node.NoClobber || node.Backup) {
+					return tokens, fmt.Errorf("`%s` does not support `%s` with `%s`, `%s`, `%s` or `%s`", keyword, FlagARCHIVE, FlagAS, FlagIFNEWER, FlagNOCLOBBER, FlagBACKUP)
+				}
+
+				tokens = append(tokens, Token{
+					Key:  keyword,
+					Node: node,
+				})
+
+				break
+			case ActionSYNC:
+				// SYNC ./dist /srv/app/public DELETE CHECKSUM
+				// SYNC REMOTE /var/log/app ./logs APPEND
+				value, flags := cutValueFlags(value, FlagDELETE, FlagCHECKSUM, FlagAPPEND)
+				valueLength := len(value)
+
+				remote := valueLength == 3 && value[0] == FlagREMOTE
+
+				if remote {
+					value = value[1:]
+					valueLength = len(value)
+				}
+
+				if valueLength != 2 {
+					return tokens, fmt.Errorf("`%s` need to match `%s [REMOTE] <source dir> <destination dir> [DELETE] [CHECKSUM] [APPEND]` format but got `%s`", keyword, keyword, valueStr)
+				}
+
+				if flags[FlagAPPEND] && !remote {
+					return tokens, fmt.Errorf("`%s` only accepts `%s` with `%s` but got `%s`", keyword, FlagAPPEND, FlagREMOTE, valueStr)
+				}
+
 				tokens = append(tokens, Token{
 					Key: keyword,
-					Node: NodeUpload{
-						SourceFiles:    value[:len(value)-1],
-						DestinationDir: value[len(value)-1],
-						SourceCode:     valueStr,
+					Node: NodeSync{
+						Source:      value[0],
+						Destination: value[1],
+						Remote:      remote,
+						Delete:      flags[FlagDELETE],
+						Checksum:    flags[FlagCHECKSUM],
+						Append:      flags[FlagAPPEND],
+						SourceCode:  valueStr,
 					},
 				})
+				break
+			case ActionCHECKSUM:
+				// CHECKSUM /srv/app/release.tar.gz SHA256 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+				if valueLength != 3 {
+					return tokens, fmt.Errorf("`%s` need to match `%s <path> %s <checksum>` format but got `%s`", keyword, keyword, ChecksumSHA256, valueStr)
+				}
 
+				if value[1] != ChecksumSHA256 {
+					return tokens, fmt.Errorf("`%s` only supports `%s` but got `%s`", keyword, ChecksumSHA256, value[1])
+				}
+
+				tokens = append(tokens, Token{
+					Key: keyword,
+					Node: NodeChecksum{
+						Path:       value[0],
+						Algorithm:  value[1],
+						Expected:   value[2],
+						SourceCode: valueStr,
+					},
+				})
 				break
 			case ActionCOPY:
 				fallthrough
@@ -364,13 +764,244 @@ func Tokenizer(input string) ([]Token, error) {
 				})
 				break
 			case ActionDELETE:
-				if valueLength < 1 {
+				value, exclude, err := cutExclude(value)
+
+				if err != nil {
+					return tokens, err
+				}
+
+				if len(value) < 1 {
 					return tokens, fmt.Errorf("`%s` accepts at least one parameter but got `%s`", keyword, valueStr)
 				}
 				tokens = append(tokens, Token{
 					Key: keyword,
 					Node: NodeDelete{
 						Targets:    value,
+						Exclude:    exclude,
+						SourceCode: valueStr,
+					},
+				})
+				break
+			case ActionMKDIR:
+				parents := value[0] == FlagPARENTS
+
+				if parents {
+					value = value[1:]
+				}
+
+				if len(value) == 0 {
+					return tokens, fmt.Errorf("`%s` accepts at least one dir but got `%s`", keyword, valueStr)
+				}
+
+				tokens = append(tokens, Token{
+					Key: keyword,
+					Node: NodeMkdir{
+						Targets:    value,
+						Parents:    parents,
+						SourceCode: valueStr,
+					},
+				})
+				break
+			case ActionCHMOD, ActionCHOWN:
+				recursive := value[valueLength-1] == FlagRECURSIVE
+
+				if recursive {
+					value = value[:valueLength-1]
+				}
+
+				if len(value) != 2 {
+					return tokens, fmt.Errorf("`%s` need to match `%s <mode|owner> <path> [RECURSIVE]` format but got `%s`", keyword, keyword, valueStr)
+				}
+
+				if keyword == ActionCHMOD {
+					mode, err := parseFileMode(value[0])
+
+					if err != nil {
+						return tokens, err
+					}
+
+					tokens = append(tokens, Token{
+						Key: keyword,
+						Node: NodeChmod{
+							Mode:       mode,
+							Target:     value[1],
+							Recursive:  recursive,
+							SourceCode: valueStr,
+						},
+					})
+				} else {
+					matchers := ownerReg.FindStringSubmatch(value[0])
+
+					if matchers == nil || matchers[1] == "" && matchers[2] == "" {
+						return tokens, fmt.Errorf("`%s` need to match `user[:group]` format but got `%s`", keyword, value[0])
+					}
+
+					tokens = append(tokens, Token{
+						Key: keyword,
+						Node: NodeChown{
+							Owner:      matchers[1],
+							Group:      matchers[2],
+							Target:     value[1],
+							Recursive:  recursive,
+							SourceCode: valueStr,
+						},
+					})
+				}
+				break
+			case ActionLINK:
+				if valueLength != 2 {
+					return tokens, fmt.Errorf("`%s` only accepts two string but got `%s`", keyword, valueStr)
+				}
+				tokens = append(tokens, Token{
+					Key: keyword,
+					Node: NodeLink{
+						Target:     value[0],
+						Name:       value[1],
+						SourceCode: valueStr,
+					},
+				})
+				break
+			case ActionTOUCH:
+				tokens = append(tokens, Token{
+					Key: keyword,
+					Node: NodeTouch{
+						Targets:    value,
+						SourceCode: valueStr,
+					},
+				})
+				break
+			case ActionTEMPLATE:
+				// TEMPLATE nginx.conf.tmpl /etc/nginx/sites-enabled/app.conf MODE 0644
+				var mode os.FileMode
+
+				if valueLength == 4 && value[2] == FlagMODE {
+					m, err := parseFileMode(value[3])
+
+					if err != nil {
+						return tokens, err
+					}
+
+					mode = m
+					value = value[:2]
+				}
+
+				if len(value) != 2 {
+					return tokens, fmt.Errorf("`%s` need to match `%s <template> <destination> [MODE <mode>]` format but got `%s`", keyword, keyword, valueStr)
+				}
+
+				tokens = append(tokens, Token{
+					Key: keyword,
+					Node: NodeTemplate{
+						Source:      value[0],
+						Destination: value[1],
+						Mode:        mode,
+						SourceCode:  valueStr,
+					},
+				})
+				break
+			case ActionWRITE, ActionAPPEND:
+				// WRITE /srv/app/.env MODE 0600 <<EOF
+				// APPEND /etc/hosts "10.0.0.5 db"
+				matchers := writeReg.FindStringSubmatch(rawValue)
+
+				if matchers == nil {
+					return tokens, fmt.Errorf("`%s` need to match `%s <path> [MODE <mode>] <content|<<EOF>` format but got `%s`", keyword, keyword, rawValue)
+				}
+
+				node := NodeWrite{
+					Path:       matchers[1],
+					SourceCode: rawValue,
+				}
+
+				if matchers[2] != "" {
+					if keyword == ActionAPPEND {
+						return tokens, fmt.Errorf("`%s` does not support `%s`", keyword, FlagMODE)
+					}
+
+					mode, err := parseFileMode(matchers[2])
+
+					if err != nil {
+						return tokens, err
+					}
+
+					node.Mode = mode
+				}
+
+				if heredoc != nil {
+					if matchers[3] != "" {
+						return tokens, fmt.Errorf("`%s` does not accept content with heredoc but got `%s`", keyword, matchers[3])
+					}
+					node.Content = *heredoc
+				} else if matchers[3] != "" {
+					node.Content = unquote(matchers[3])
+				} else {
+					return tokens, fmt.Errorf("`%s` require content but got `%s`", keyword, rawValue)
+				}
+
+				tokens = append(tokens, Token{
+					Key:  keyword,
+					Node: node,
+				})
+				break
+			case ActionLINEINFILE:
+				// LINEINFILE /etc/sysctl.conf "vm.swappiness=10" MATCH ^vm.swappiness
+				matchers := lineInFileReg.FindStringSubmatch(rawValue)
+
+				if matchers == nil {
+					return tokens, fmt.Errorf("`%s` need to match `%s <path> <line> [MATCH <regexp>]` format but got `%s`", keyword, keyword, rawValue)
+				}
+
+				match := unquote(matchers[3])
+
+				if err := validateRegexp(match); err != nil {
+					return tokens, err
+				}
+
+				tokens = append(tokens, Token{
+					Key: keyword,
+					Node: NodeLineInFile{
+						Path:       matchers[1],
+						Line:       unquote(matchers[2]),
+						Match:      match,
+						SourceCode: rawValue,
+					},
+				})
+				break
+			case ActionREPLACE:
+				// REPLACE /srv/app/config.yml /port: \d+/ "port: {{PORT}}"
+				matchers := replaceReg.FindStringSubmatch(rawValue)
+
+				if matchers == nil {
+					return tokens, fmt.Errorf("`%s` need to match `%s <path> /<regexp>/ <replacement>` format but got `%s`", keyword, keyword, rawValue)
+				}
+
+				if err := validateRegexp(matchers[2]); err != nil {
+					return tokens, err
+				}
+
+				tokens = append(tokens, Token{
+					Key: keyword,
+					Node: NodeReplace{
+						Path:        matchers[1],
+						Pattern:     matchers[2],
+						Replacement: unquote(matchers[3]),
+						SourceCode:  rawValue,
+					},
+				})
+				break
+			case ActionREAD:
+				// READ CONFIG = /srv/app/config.json
+				matchers := readReg.FindStringSubmatch(valueStr)
+
+				if matchers == nil {
+					return tokens, fmt.Errorf("`%s` need to match `%s <key> = <path>` format but got `%s`", keyword, keyword, valueStr)
+				}
+
+				tokens = append(tokens, Token{
+					Key: keyword,
+					Node: NodeRead{
+						Key:        matchers[1],
+						Path:       matchers[2],
 						SourceCode: valueStr,
 					},
 				})
@@ -382,32 +1013,220 @@ func Tokenizer(input string) ([]Token, error) {
 
 				commands := make([]NodeRunCommand, 0)
 
-				cmd := strings.TrimSpace(valueStr)
+				// RUN systemctl is-active nginx ALLOW_FAIL REGISTER result
+				var (
+					cmdStr   = valueStr
+					flags    = map[string]bool{}
+					register = ""
+				)
+
+				for {
+					var (
+						previous = cmdStr
+						found    map[string]bool
+					)
 
-				command := NodeRunCommand{SourceCode: cmd}
+					cmdStr, found = cutSuffixFlags(cmdStr, FlagIDEMPOTENT, FlagALLOWFAIL)
 
-				if strings.HasPrefix(cmd, "[") && strings.HasSuffix(cmd, "]") {
-					command.RunInLocal = true
-					if err := json.Unmarshal([]byte(cmd), &command.Command); err != nil {
-						return tokens, fmt.Errorf("invalid local command '%s'", cmd)
+					for flag := range found {
+						flags[flag] = true
+					}
+
+					if matchers := registerReg.FindStringSubmatch(cmdStr); matchers != nil {
+						register = matchers[1]
+						cmdStr = registerReg.ReplaceAllString(cmdStr, "")
+					}
+
+					if cmdStr == previous {
+						break
 					}
-				} else {
-					command.RunInLocal = false
-					command.Command = trimArrayString(strings.Split(cmd, "&&"))
 				}
 
-				commands = append(commands, command)
+				cmd := strings.TrimSpace(cmdStr)
+
+				// RUN TTY top
+				tty := strings.HasPrefix(cmd, FlagTTY+spaceBlank)
+
+				if tty {
+					cmd = strings.TrimSpace(strings.TrimPrefix(cmd, FlagTTY))
+				}
+
+				// the commands are connected with pipe. eg. RUN ["tar", "czf", "-", "dist"] |> tar xzf - -C /srv/app
+				for _, segment := range splitPipe(cmd) {
+					command, err := parseRunCommand(strings.TrimSpace(segment))
+
+					if err != nil {
+						return tokens, err
+					}
+
+					commands = append(commands, command)
+				}
+
+				if tty && len(commands) > 1 {
+					return tokens, fmt.Errorf("`%s` does not support `%s` with pipe", keyword, FlagTTY)
+				}
 
 				tokens = append(tokens, Token{
 					Key: keyword,
 					Node: NodeRun{
 						Commands:   commands,
+						Idempotent: flags[FlagIDEMPOTENT],
+						Tty:        tty,
+						AllowFail:  flags[FlagALLOWFAIL],
+						Register:   register,
 						SourceCode: valueStr,
 					},
 				})
 
 				break
 
+			case ActionTUNNEL:
+				matchers := tunnelReg.FindStringSubmatch(valueStr)
+
+				if matchers == nil {
+					return tokens, fmt.Errorf("`%s` need to match `LOCAL|REMOTE <port> TO <host>:<port>` format but got `%s`", keyword, valueStr)
+				}
+
+				tokens = append(tokens, Token{
+					Key: keyword,
+					Node: NodeTunnel{
+						Remote:     matchers[1] == "REMOTE",
+						Port:       matchers[2],
+						Target:     matchers[3],
+						SourceCode: valueStr,
+					},
+				})
+				break
+			case ActionSUDO:
+				// SUDO RUN systemctl restart nginx
+				subTokens, err := Tokenizer(valueStr)
+
+				if err != nil {
+					return tokens, err
+				}
+
+				if len(subTokens) != 1 {
+					return tokens, fmt.Errorf("`%s` only accepts one action but got `%s`", keyword, valueStr)
+				}
+
+				subToken := subTokens[0]
+
+				switch node := subToken.Node.(type) {
+				case NodeRun:
+					remote := false
+
+					for _, cmd := range node.Commands {
+						remote = remote || !cmd.RunInLocal
+					}
+
+					// sudo only applies to the remote commands
+					if !remote {
+						return tokens, fmt.Errorf("`%s` does not support local command `%s`", keyword, node.SourceCode)
+					}
+
+					node.Sudo = true
+					subToken.Node = node
+				case NodeUpload:
+					if subToken.Key != ActionUPLOAD {
+						return tokens, fmt.Errorf("`%s` does not support `%s`", keyword, subToken.Key)
+					}
+					node.Sudo = true
+					subToken.Node = node
+				default:
+					return tokens, fmt.Errorf("`%s` does not support `%s`", keyword, subToken.Key)
+				}
+
+				tokens = append(tokens, subToken)
+				break
+			case ActionLOCAL:
+				// LOCAL RUN npm run build && npm test
+				subTokens, err := Tokenizer(valueStr)
+
+				if err != nil {
+					return tokens, err
+				}
+
+				if len(subTokens) != 1 {
+					return tokens, fmt.Errorf("`%s` only accepts one action but got `%s`", keyword, valueStr)
+				}
+
+				subToken := subTokens[0]
+
+				switch node := subToken.Node.(type) {
+				case NodeRun:
+					if node.Sudo {
+						return tokens, fmt.Errorf("`%s` does not support `%s`", keyword, ActionSUDO)
+					}
+
+					commands := make([]NodeRunCommand, len(node.Commands))
+
+					for index, cmd := range node.Commands {
+						// the command in JSON array format is already local
+						if !cmd.RunInLocal {
+							cmd.RunInLocal = true
+							cmd.Shell = true
+						}
+						commands[index] = cmd
+					}
+
+					node.Commands = commands
+					subToken.Node = node
+				case NodeEnv:
+					node.Local = true
+					subToken.Node = node
+				case NodeShell:
+					node.Local = true
+					subToken.Node = node
+				case NodeCd:
+					if subToken.Key != ActionPUSHD {
+						return tokens, fmt.Errorf("`%s` does not support `%s`", keyword, subToken.Key)
+					}
+					node.Local = true
+					subToken.Node = node
+				case NodePopd:
+					node.Local = true
+					subToken.Node = node
+				default:
+					return tokens, fmt.Errorf("`%s` does not support `%s`", keyword, subToken.Key)
+				}
+
+				tokens = append(tokens, subToken)
+				break
+			case ActionBECOME:
+				if valueLength != 1 {
+					return tokens, fmt.Errorf("`%s` only accepts one string but got `%s`", keyword, valueStr)
+				}
+
+				tokens = append(tokens, Token{
+					Key: keyword,
+					Node: NodeBecome{
+						User:       valueStr,
+						SourceCode: valueStr,
+					},
+				})
+				break
+			case ActionSHELL:
+				tokens = append(tokens, Token{
+					Key: keyword,
+					Node: NodeShell{
+						Shell:      value,
+						SourceCode: valueStr,
+					},
+				})
+				break
+			case ActionEND:
+				// END TUNNEL
+				switch valueStr {
+				case "", ActionTUNNEL, ActionBECOME, ActionSHELL, ActionLOCAL + spaceBlank + ActionSHELL:
+				default:
+					return tokens, fmt.Errorf("`%s` only accepts `%s`, `%s`, `%s` or `%s %s` but got `%s`", keyword, ActionTUNNEL, ActionBECOME, ActionSHELL, ActionLOCAL, ActionSHELL, valueStr)
+				}
+
+				tokens = append(tokens, Token{
+					Key:  keyword,
+					Node: NodeEnd{Action: valueStr},
+				})
+				break
 			case ActionVAR:
 				varNode := NodeVar{}
 
@@ -463,3 +1282,288 @@ func trimArrayString(arr []string) []string {
 
 	return r
 }
+
+// parse the command of RUN.
+// local command: ["gzip", "-c"] > backup.sql.gz
+// remote command: npm run build && npm run test
+// split the command by the pipe operator which is not in quotes. eg. `echo 'a |> b' |> ["grep", "|>"]`
+func splitPipe(cmd string) []string {
+	var (
+		segments []string
+		quote    byte // the quote which the current char is in. zero means not in quotes
+		start    int
+	)
+
+	for i := 0; i < len(cmd); i++ {
+		c := cmd[i]
+
+		switch {
+		case quote == '\'':
+			// there is no escape in single quotes of shell
+			if c == '\'' {
+				quote = 0
+			}
+		case quote == '"':
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				quote = 0
+			}
+		case c == '\\':
+			i++
+		case c == '\'' || c == '"':
+			quote = c
+		case strings.HasPrefix(cmd[i:], pipeOperator):
+			segments = append(segments, cmd[start:i])
+			i += len(pipeOperator) - 1
+			start = i + 1
+		}
+	}
+
+	return append(segments, cmd[start:])
+}
+
+func parseRunCommand(cmd string) (NodeRunCommand, error) {
+	command := NodeRunCommand{SourceCode: cmd}
+
+	if cmd == "" {
+		return command, errors.New("empty command for `RUN`")
+	}
+
+	if strings.HasPrefix(cmd, "[") {
+		end := strings.LastIndex(cmd, "]")
+
+		if end == -1 {
+			return command, fmt.Errorf("invalid local command '%s'", cmd)
+		}
+
+		command.RunInLocal = true
+
+		if err := json.Unmarshal([]byte(cmd[:end+1]), &command.Command); err != nil || len(command.Command) == 0 {
+			return command, fmt.Errorf("invalid local command '%s'", cmd)
+		}
+
+		// redirect the stdout to file
+		if rest := strings.TrimSpace(cmd[end+1:]); rest != "" {
+			if !strings.HasPrefix(rest, ">") || strings.TrimSpace(rest[1:]) == "" {
+				return command, fmt.Errorf("invalid local command '%s'", cmd)
+			}
+
+			command.Output = strings.TrimSpace(rest[1:])
+		}
+	} else {
+		command.RunInLocal = false
+		command.Command = trimArrayString(strings.Split(cmd, "&&"))
+	}
+
+	return command, nil
+}
+
+// remove the quotes around the string. eg. "hello world" -> hello world
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+
+	return s
+}
+
+// read the content of heredoc until the line of delimiter. index should be at the end of first line.
+// it returns the content and the index after the delimiter
+func readHeredoc(input string, index int, delimiter string) (string, int, error) {
+	var lines []string
+
+	// skip the line break of first line
+	if index < len(input) && input[index] == '\r' {
+		index++
+	}
+
+	if index < len(input) && input[index] == '\n' {
+		index++
+	}
+
+	for index < len(input) {
+		end := strings.IndexByte(input[index:], '\n')
+
+		if end < 0 {
+			end = len(input)
+		} else {
+			end += index
+		}
+
+		line := strings.TrimSuffix(input[index:end], "\r")
+
+		index = end
+
+		if strings.TrimSpace(line) == delimiter {
+			return strings.Join(lines, "\n") + "\n", index, nil
+		}
+
+		lines = append(lines, line)
+
+		// skip the line break
+		index++
+	}
+
+	return "", index, fmt.Errorf("heredoc is not closed by `%s`", delimiter)
+}
+
+// validate the regular expression. it may contain variables which can not be validated until running
+func validateRegexp(pattern string) error {
+	if strings.Contains(pattern, "{{") {
+		return nil
+	}
+
+	if _, err := regexp.Compile(pattern); err != nil {
+		return fmt.Errorf("invalid regular expression `%s`: %w", pattern, err)
+	}
+
+	return nil
+}
+
+// parse the file mode in octal. eg. 0644
+func parseFileMode(s string) (os.FileMode, error) {
+	if !fileModeReg.MatchString(s) {
+		return 0, fmt.Errorf("invalid file mode `%s`, it should be octal number like `0644`", s)
+	}
+
+	mode, err := strconv.ParseUint(s, 8, 32)
+
+	if err != nil {
+		return 0, err
+	}
+
+	fileMode := os.FileMode(mode).Perm()
+
+	// the special bits of os.FileMode are not the same as unix
+	if mode&04000 != 0 {
+		fileMode |= os.ModeSetuid
+	}
+
+	if mode&02000 != 0 {
+		fileMode |= os.ModeSetgid
+	}
+
+	if mode&01000 != 0 {
+		fileMode |= os.ModeSticky
+	}
+
+	return fileMode, nil
+}
+
+// cut the `EXCLUDE` clause at the end of value. eg. `./dist ./static EXCLUDE node_modules *.map`
+func cutExclude(value []string) ([]string, []string, error) {
+	for index := len(value) - 1; index >= 0; index-- {
+		if value[index] != FlagEXCLUDE {
+			continue
+		}
+
+		if index == len(value)-1 {
+			return value, nil, fmt.Errorf("`%s` requires at least one pattern", FlagEXCLUDE)
+		}
+
+		return value[:index], value[index+1:], nil
+	}
+
+	return value, nil, nil
+}
+
+// cut the option with a value among the flags at the end of value. eg. `./dist /srv PARALLEL 8 VERIFY`.
+// the option value is empty if the option is not found
+func cutValueOption(value []string, option string) ([]string, string, error) {
+	for index := len(value) - 1; index > 0; index-- {
+		if value[index] != option {
+			continue
+		}
+
+		if index == len(value)-1 {
+			return value, "", fmt.Errorf("`%s` requires a value", option)
+		}
+
+		result := append(append([]string{}, value[:index]...), value[index+2:]...)
+
+		return result, value[index+1], nil
+	}
+
+	return value, "", nil
+}
+
+// cut the `PARALLEL <n>` option. zero means not set
+func cutParallel(value []string) ([]string, int, error) {
+	value, option, err := cutValueOption(value, FlagPARALLEL)
+
+	if err != nil || option == "" {
+		return value, 0, err
+	}
+
+	n, err := strconv.Atoi(option)
+
+	if err != nil || n < 1 {
+		return value, 0, fmt.Errorf("`%s` requires a positive number but got `%s`", FlagPARALLEL, option)
+	}
+
+	return value, n, nil
+}
+
+// cut the `PRESERVE <attributes>` option. eg. `PRESERVE times,owner,links`
+func cutPreserve(value []string) ([]string, []string, error) {
+	value, option, err := cutValueOption(value, FlagPRESERVE)
+
+	if err != nil || option == "" {
+		return value, nil, err
+	}
+
+	attributes := strings.Split(option, ",")
+
+	for _, attribute := range attributes {
+		switch attribute {
+		case PreserveTIMES, PreserveOWNER, PreserveLINKS:
+		default:
+			return value, nil, fmt.Errorf("`%s` only accepts `%s`, `%s` and `%s` but got `%s`", FlagPRESERVE, PreserveTIMES, PreserveOWNER, PreserveLINKS, attribute)
+		}
+	}
+
+	return value, attributes, nil
+}
+
+// cut the flags at the end of the words of value
+func cutValueFlags(value []string, flags ...string) ([]string, map[string]bool) {
+	result := map[string]bool{}
+
+findFlag:
+	for len(value) != 0 {
+		for _, flag := range flags {
+			if result[flag] == false && value[len(value)-1] == flag {
+				result[flag] = true
+				value = value[:len(value)-1]
+				continue findFlag
+			}
+		}
+
+		break findFlag
+	}
+
+	return value, result
+}
+
+// cut the flags at the end of value. eg `echo hello IDEMPOTENT`
+func cutSuffixFlags(value string, flags ...string) (string, map[string]bool) {
+	result := map[string]bool{}
+
+findFlag:
+	for {
+		trimmed := strings.TrimRight(value, " \t")
+
+		for _, flag := range flags {
+			if result[flag] == false && strings.HasSuffix(trimmed, spaceBlank+flag) {
+				result[flag] = true
+				value = strings.TrimSuffix(trimmed, flag)
+				continue findFlag
+			}
+		}
+
+		break findFlag
+	}
+
+	return value, result
+}
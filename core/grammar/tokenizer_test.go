@@ -3,6 +3,7 @@ package grammar_test
 import (
 	"encoding/json"
 	"fmt"
+	"os"
 	"reflect"
 	"testing"
 
@@ -458,6 +459,1198 @@ RUN yarn \
 			},
 			wantErr: false,
 		},
+		{
+			name: "tunnel block",
+			args: args{
+				input: `
+TUNNEL LOCAL 15432 TO localhost:5432
+TUNNEL REMOTE 9000 TO localhost:9000
+END TUNNEL
+END
+`,
+			},
+			want: []grammar.Token{
+				{
+					Key: grammar.ActionTUNNEL,
+					Node: grammar.NodeTunnel{
+						Remote:     false,
+						Port:       "15432",
+						Target:     "localhost:5432",
+						SourceCode: "LOCAL 15432 TO localhost:5432",
+					},
+				},
+				{
+					Key: grammar.ActionTUNNEL,
+					Node: grammar.NodeTunnel{
+						Remote:     true,
+						Port:       "9000",
+						Target:     "localhost:9000",
+						SourceCode: "REMOTE 9000 TO localhost:9000",
+					},
+				},
+				{
+					Key:  grammar.ActionEND,
+					Node: grammar.NodeEnd{Action: grammar.ActionTUNNEL},
+				},
+				{
+					Key:  grammar.ActionEND,
+					Node: grammar.NodeEnd{},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid tunnel",
+			args: args{
+				input: `TUNNEL LOCAL 15432 localhost:5432`,
+			},
+			want:    []grammar.Token{},
+			wantErr: true,
+		},
+		{
+			name: "END with invalid block",
+			args: args{
+				input: `END RUN`,
+			},
+			want:    []grammar.Token{},
+			wantErr: true,
+		},
+		{
+			name: "idempotent RUN",
+			args: args{
+				input: `RUN apt-get update IDEMPOTENT`,
+			},
+			want: []grammar.Token{
+				{
+					Key: "RUN",
+					Node: grammar.NodeRun{
+						Commands: []grammar.NodeRunCommand{
+							{
+								Command:    []string{"apt-get update"},
+								RunInLocal: false,
+								SourceCode: "apt-get update",
+							},
+						},
+						Idempotent: true,
+						SourceCode: "apt-get update IDEMPOTENT",
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "RUN with TTY",
+			args: args{
+				input: `RUN TTY apt-get upgrade`,
+			},
+			want: []grammar.Token{
+				{
+					Key: "RUN",
+					Node: grammar.NodeRun{
+						Commands: []grammar.NodeRunCommand{
+							{
+								Command:    []string{"apt-get upgrade"},
+								RunInLocal: false,
+								SourceCode: "apt-get upgrade",
+							},
+						},
+						Tty:        true,
+						SourceCode: "TTY apt-get upgrade",
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "SUDO",
+			args: args{
+				input: `
+SUDO RUN systemctl restart nginx
+SUDO UPLOAD nginx.conf /etc/nginx
+BECOME www
+	RUN whoami
+END
+`,
+			},
+			want: []grammar.Token{
+				{
+					Key: "RUN",
+					Node: grammar.NodeRun{
+						Commands: []grammar.NodeRunCommand{
+							{
+								Command:    []string{"systemctl restart nginx"},
+								RunInLocal: false,
+								SourceCode: "systemctl restart nginx",
+							},
+						},
+						Sudo:       true,
+						SourceCode: "systemctl restart nginx",
+					},
+				},
+				{
+					Key: "UPLOAD",
+					Node: grammar.NodeUpload{
+						SourceFiles:    []string{"nginx.conf"},
+						DestinationDir: "/etc/nginx",
+						Sudo:           true,
+						SourceCode:     "nginx.conf /etc/nginx",
+					},
+				},
+				{
+					Key: "BECOME",
+					Node: grammar.NodeBecome{
+						User:       "www",
+						SourceCode: "www",
+					},
+				},
+				{
+					Key: "RUN",
+					Node: grammar.NodeRun{
+						Commands: []grammar.NodeRunCommand{
+							{
+								Command:    []string{"whoami"},
+								RunInLocal: false,
+								SourceCode: "whoami",
+							},
+						},
+						SourceCode: "whoami",
+					},
+				},
+				{
+					Key:  grammar.ActionEND,
+					Node: grammar.NodeEnd{},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "SUDO with unsupported action",
+			args: args{
+				input: `SUDO DOWNLOAD nginx.conf ./`,
+			},
+			want:    []grammar.Token{},
+			wantErr: true,
+		},
+		{
+			name: "SUDO with local command",
+			args: args{
+				input: `SUDO RUN ["ls", "-l"]`,
+			},
+			want:    []grammar.Token{},
+			wantErr: true,
+		},
+		{
+			name: "RUN with pipe",
+			args: args{
+				input: `
+RUN ["tar", "czf", "-", "dist"] |> tar xzf - -C /srv/app
+RUN pg_dump app |> ["gzip", "-c"] > backup.sql.gz
+`,
+			},
+			want: []grammar.Token{
+				{
+					Key: "RUN",
+					Node: grammar.NodeRun{
+						Commands: []grammar.NodeRunCommand{
+							{
+								Command:    []string{"tar", "czf", "-", "dist"},
+								RunInLocal: true,
+								SourceCode: `["tar", "czf", "-", "dist"]`,
+							},
+							{
+								Command:    []string{"tar xzf - -C /srv/app"},
+								RunInLocal: false,
+								SourceCode: "tar xzf - -C /srv/app",
+							},
+						},
+						SourceCode: `["tar", "czf", "-", "dist"] |> tar xzf - -C /srv/app`,
+					},
+				},
+				{
+					Key: "RUN",
+					Node: grammar.NodeRun{
+						Commands: []grammar.NodeRunCommand{
+							{
+								Command:    []string{"pg_dump app"},
+								RunInLocal: false,
+								SourceCode: "pg_dump app",
+							},
+							{
+								Command:    []string{"gzip", "-c"},
+								RunInLocal: true,
+								Output:     "backup.sql.gz",
+								SourceCode: `["gzip", "-c"] > backup.sql.gz`,
+							},
+						},
+						SourceCode: `pg_dump app |> ["gzip", "-c"] > backup.sql.gz`,
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "RUN with pipe operator in quotes",
+			args: args{
+				input: `
+RUN echo 'a |> b'
+RUN echo "a \" |> b" |> ["grep", "|>"]
+`,
+			},
+			want: []grammar.Token{
+				{
+					Key: "RUN",
+					Node: grammar.NodeRun{
+						Commands: []grammar.NodeRunCommand{
+							{
+								Command:    []string{"echo 'a |> b'"},
+								RunInLocal: false,
+								SourceCode: "echo 'a |> b'",
+							},
+						},
+						SourceCode: "echo 'a |> b'",
+					},
+				},
+				{
+					Key: "RUN",
+					Node: grammar.NodeRun{
+						Commands: []grammar.NodeRunCommand{
+							{
+								Command:    []string{`echo "a \" |> b"`},
+								RunInLocal: false,
+								SourceCode: `echo "a \" |> b"`,
+							},
+							{
+								Command:    []string{"grep", "|>"},
+								RunInLocal: true,
+								SourceCode: `["grep", "|>"]`,
+							},
+						},
+						SourceCode: `echo "a \" |> b" |> ["grep", "|>"]`,
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "RUN with empty pipe",
+			args: args{
+				input: `RUN pg_dump app |>`,
+			},
+			want:    []grammar.Token{},
+			wantErr: true,
+		},
+		{
+			name: "RUN with REGISTER and ALLOW_FAIL",
+			args: args{
+				input: `RUN systemctl is-active nginx REGISTER result ALLOW_FAIL`,
+			},
+			want: []grammar.Token{
+				{
+					Key: "RUN",
+					Node: grammar.NodeRun{
+						Commands: []grammar.NodeRunCommand{
+							{
+								Command:    []string{"systemctl is-active nginx"},
+								RunInLocal: false,
+								SourceCode: "systemctl is-active nginx",
+							},
+						},
+						AllowFail:  true,
+						Register:   "result",
+						SourceCode: "systemctl is-active nginx REGISTER result ALLOW_FAIL",
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "parse ENV with spaces and quotes",
+			args: args{
+				input: `
+ENV GREETING = "hello world"
+ENV COMMAND=a; rm -rf ~
+`,
+			},
+			want: []grammar.Token{
+				{
+					Key: "ENV",
+					Node: grammar.NodeEnv{
+						Key:        "GREETING",
+						Value:      "hello world",
+						SourceCode: `GREETING = "hello world"`,
+					},
+				},
+				{
+					Key: "ENV",
+					Node: grammar.NodeEnv{
+						Key:        "COMMAND",
+						Value:      "a; rm -rf ~",
+						SourceCode: "COMMAND=a; rm -rf ~",
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Invalid ENV key",
+			args: args{
+				input: "ENV 1KEY = value",
+			},
+			want:    []grammar.Token{},
+			wantErr: true,
+		},
+		{
+			name: "shell",
+			args: args{
+				input: `
+SHELL bash -lc
+	RUN node -v
+END
+`,
+			},
+			want: []grammar.Token{
+				{
+					Key: grammar.ActionSHELL,
+					Node: grammar.NodeShell{
+						Shell:      []string{"bash", "-lc"},
+						SourceCode: "bash -lc",
+					},
+				},
+				{
+					Key: "RUN",
+					Node: grammar.NodeRun{
+						Commands: []grammar.NodeRunCommand{
+							{
+								Command:    []string{"node -v"},
+								RunInLocal: false,
+								SourceCode: "node -v",
+							},
+						},
+						SourceCode: "node -v",
+					},
+				},
+				{
+					Key:  grammar.ActionEND,
+					Node: grammar.NodeEnd{},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "local",
+			args: args{
+				input: `
+LOCAL ENV NODE_ENV = production
+LOCAL SHELL bash -c
+LOCAL RUN npm run build && npm test | tee build.log
+`,
+			},
+			want: []grammar.Token{
+				{
+					Key: grammar.ActionENV,
+					Node: grammar.NodeEnv{
+						Key:        "NODE_ENV",
+						Value:      "production",
+						Local:      true,
+						SourceCode: "NODE_ENV = production",
+					},
+				},
+				{
+					Key: grammar.ActionSHELL,
+					Node: grammar.NodeShell{
+						Shell:      []string{"bash", "-c"},
+						Local:      true,
+						SourceCode: "bash -c",
+					},
+				},
+				{
+					Key: grammar.ActionRUN,
+					Node: grammar.NodeRun{
+						Commands: []grammar.NodeRunCommand{
+							{
+								Command:    []string{"npm run build", "npm test | tee build.log"},
+								RunInLocal: true,
+								Shell:      true,
+								SourceCode: "npm run build && npm test | tee build.log",
+							},
+						},
+						SourceCode: "npm run build && npm test | tee build.log",
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "local with unsupported action",
+			args: args{
+				input: `LOCAL UPLOAD a.txt /srv`,
+			},
+			want:    []grammar.Token{},
+			wantErr: true,
+		},
+		{
+			name: "directory stack",
+			args: args{
+				input: `
+LCD ./dist
+PUSHD /srv/app
+LOCAL PUSHD ./build
+LOCAL POPD
+POPD
+`,
+			},
+			want: []grammar.Token{
+				{
+					Key: grammar.ActionLCD,
+					Node: grammar.NodeCd{
+						Target:     "./dist",
+						Local:      true,
+						SourceCode: "./dist",
+					},
+				},
+				{
+					Key: grammar.ActionPUSHD,
+					Node: grammar.NodeCd{
+						Target:     "/srv/app",
+						SourceCode: "/srv/app",
+					},
+				},
+				{
+					Key: grammar.ActionPUSHD,
+					Node: grammar.NodeCd{
+						Target:     "./build",
+						Local:      true,
+						SourceCode: "./build",
+					},
+				},
+				{
+					Key:  grammar.ActionPOPD,
+					Node: grammar.NodePopd{Local: true},
+				},
+				{
+					Key:  grammar.ActionPOPD,
+					Node: grammar.NodePopd{},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "POPD with value",
+			args: args{
+				input: `POPD /srv`,
+			},
+			want:    []grammar.Token{},
+			wantErr: true,
+		},
+		{
+			name: "remote file management",
+			args: args{
+				input: `
+MKDIR -p /srv/app/releases /srv/app/shared
+CHMOD 0755 /srv/app RECURSIVE
+CHMOD 4755 /srv/app/bin/tool
+CHOWN www:www /srv/app RECURSIVE
+CHOWN :deploy shared
+LINK releases/v2 /srv/app/current
+TOUCH /srv/app/restart.txt
+`,
+			},
+			want: []grammar.Token{
+				{
+					Key: grammar.ActionMKDIR,
+					Node: grammar.NodeMkdir{
+						Targets:    []string{"/srv/app/releases", "/srv/app/shared"},
+						Parents:    true,
+						SourceCode: "-p /srv/app/releases /srv/app/shared",
+					},
+				},
+				{
+					Key: grammar.ActionCHMOD,
+					Node: grammar.NodeChmod{
+						Mode:       0755,
+						Target:     "/srv/app",
+						Recursive:  true,
+						SourceCode: "0755 /srv/app RECURSIVE",
+					},
+				},
+				{
+					Key: grammar.ActionCHMOD,
+					Node: grammar.NodeChmod{
+						Mode:       0755 | os.ModeSetuid,
+						Target:     "/srv/app/bin/tool",
+						SourceCode: "4755 /srv/app/bin/tool",
+					},
+				},
+				{
+					Key: grammar.ActionCHOWN,
+					Node: grammar.NodeChown{
+						Owner:      "www",
+						Group:      "www",
+						Target:     "/srv/app",
+						Recursive:  true,
+						SourceCode: "www:www /srv/app RECURSIVE",
+					},
+				},
+				{
+					Key: grammar.ActionCHOWN,
+					Node: grammar.NodeChown{
+						Group:      "deploy",
+						Target:     "shared",
+						SourceCode: ":deploy shared",
+					},
+				},
+				{
+					Key: grammar.ActionLINK,
+					Node: grammar.NodeLink{
+						Target:     "releases/v2",
+						Name:       "/srv/app/current",
+						SourceCode: "releases/v2 /srv/app/current",
+					},
+				},
+				{
+					Key: grammar.ActionTOUCH,
+					Node: grammar.NodeTouch{
+						Targets:    []string{"/srv/app/restart.txt"},
+						SourceCode: "/srv/app/restart.txt",
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid file mode",
+			args: args{
+				input: `CHMOD u+x /srv/app/start.sh`,
+			},
+			want:    []grammar.Token{},
+			wantErr: true,
+		},
+		{
+			name: "invalid owner",
+			args: args{
+				input: `CHOWN : /srv/app`,
+			},
+			want:    []grammar.Token{},
+			wantErr: true,
+		},
+		{
+			name: "template",
+			args: args{
+				input: `
+TEMPLATE nginx.conf.tmpl /etc/nginx/sites-enabled/app.conf MODE 0644
+TEMPLATE .env.tmpl .env
+`,
+			},
+			want: []grammar.Token{
+				{
+					Key: grammar.ActionTEMPLATE,
+					Node: grammar.NodeTemplate{
+						Source:      "nginx.conf.tmpl",
+						Destination: "/etc/nginx/sites-enabled/app.conf",
+						Mode:        0644,
+						SourceCode:  "nginx.conf.tmpl /etc/nginx/sites-enabled/app.conf MODE 0644",
+					},
+				},
+				{
+					Key: grammar.ActionTEMPLATE,
+					Node: grammar.NodeTemplate{
+						Source:      ".env.tmpl",
+						Destination: ".env",
+						SourceCode:  ".env.tmpl .env",
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "template without destination",
+			args: args{
+				input: `TEMPLATE nginx.conf.tmpl MODE 0644`,
+			},
+			want:    []grammar.Token{},
+			wantErr: true,
+		},
+		{
+			name: "write append and read",
+			args: args{
+				input: `
+WRITE /srv/app/.env MODE 0600 <<EOF
+# comment is kept
+PORT={{PORT}}
+
+NAME="my app"
+EOF
+APPEND /etc/hosts "10.0.0.5   db # primary"
+APPEND /etc/hosts 10.0.0.6 cache
+READ CONFIG = /srv/app/config.json
+`,
+			},
+			want: []grammar.Token{
+				{
+					Key: grammar.ActionWRITE,
+					Node: grammar.NodeWrite{
+						Path:       "/srv/app/.env",
+						Content:    "# comment is kept\nPORT={{PORT}}\n\nNAME=\"my app\"\n",
+						Mode:       0600,
+						SourceCode: "/srv/app/.env MODE 0600",
+					},
+				},
+				{
+					Key: grammar.ActionAPPEND,
+					Node: grammar.NodeWrite{
+						Path:       "/etc/hosts",
+						Content:    "10.0.0.5   db # primary",
+						SourceCode: `/etc/hosts "10.0.0.5   db # primary"`,
+					},
+				},
+				{
+					Key: grammar.ActionAPPEND,
+					Node: grammar.NodeWrite{
+						Path:       "/etc/hosts",
+						Content:    "10.0.0.6 cache",
+						SourceCode: "/etc/hosts 10.0.0.6 cache",
+					},
+				},
+				{
+					Key: grammar.ActionREAD,
+					Node: grammar.NodeRead{
+						Key:        "CONFIG",
+						Path:       "/srv/app/config.json",
+						SourceCode: "CONFIG = /srv/app/config.json",
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "write in block",
+			args: args{
+				input: `
+BECOME www
+    WRITE index.html <<HTML
+    <h1>hello</h1>
+    HTML
+END
+`,
+			},
+			want: []grammar.Token{
+				{
+					Key: grammar.ActionBECOME,
+					Node: grammar.NodeBecome{
+						User:       "www",
+						SourceCode: "www",
+					},
+				},
+				{
+					Key: grammar.ActionWRITE,
+					Node: grammar.NodeWrite{
+						Path:       "index.html",
+						Content:    "    <h1>hello</h1>\n",
+						SourceCode: "index.html",
+					},
+				},
+				{
+					Key:  grammar.ActionEND,
+					Node: grammar.NodeEnd{},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "heredoc not closed",
+			args: args{
+				input: "WRITE .env <<EOF\nPORT=80\n",
+			},
+			want:    []grammar.Token{},
+			wantErr: true,
+		},
+		{
+			name: "write without content",
+			args: args{
+				input: "WRITE .env MODE 0600",
+			},
+			want:    []grammar.Token{},
+			wantErr: true,
+		},
+		{
+			name: "line in file and replace",
+			args: args{
+				input: `
+LINEINFILE /etc/sysctl.conf "vm.swappiness=10" MATCH ^vm.swappiness
+LINEINFILE /etc/hosts "10.0.0.5   db"
+REPLACE /srv/app/config.yml /port: \d+/ "port: {{PORT}}"
+REPLACE /srv/app/config.yml /path: \/srv\/app # old/ "path: /srv/app2"
+`,
+			},
+			want: []grammar.Token{
+				{
+					Key: grammar.ActionLINEINFILE,
+					Node: grammar.NodeLineInFile{
+						Path:       "/etc/sysctl.conf",
+						Line:       "vm.swappiness=10",
+						Match:      "^vm.swappiness",
+						SourceCode: `/etc/sysctl.conf "vm.swappiness=10" MATCH ^vm.swappiness`,
+					},
+				},
+				{
+					Key: grammar.ActionLINEINFILE,
+					Node: grammar.NodeLineInFile{
+						Path:       "/etc/hosts",
+						Line:       "10.0.0.5   db",
+						SourceCode: `/etc/hosts "10.0.0.5   db"`,
+					},
+				},
+				{
+					Key: grammar.ActionREPLACE,
+					Node: grammar.NodeReplace{
+						Path:        "/srv/app/config.yml",
+						Pattern:     `port: \d+`,
+						Replacement: "port: {{PORT}}",
+						SourceCode:  `/srv/app/config.yml /port: \d+/ "port: {{PORT}}"`,
+					},
+				},
+				{
+					Key: grammar.ActionREPLACE,
+					Node: grammar.NodeReplace{
+						Path:        "/srv/app/config.yml",
+						Pattern:     `path: \/srv\/app # old`,
+						Replacement: "path: /srv/app2",
+						SourceCode:  `/srv/app/config.yml /path: \/srv\/app # old/ "path: /srv/app2"`,
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid regular expression",
+			args: args{
+				input: `LINEINFILE /etc/sysctl.conf "vm.swappiness=10" MATCH ^vm.swappiness(`,
+			},
+			want:    []grammar.Token{},
+			wantErr: true,
+		},
+		{
+			name: "replace without replacement",
+			args: args{
+				input: `REPLACE /srv/app/config.yml /port: \d+/`,
+			},
+			want:    []grammar.Token{},
+			wantErr: true,
+		},
+		{
+			name: "sync",
+			args: args{
+				input: `
+SYNC ./dist /srv/app/public
+SYNC ./dist /srv/app/public DELETE
+SYNC ./dist /srv/app/public CHECKSUM DELETE
+`,
+			},
+			want: []grammar.Token{
+				{
+					Key: grammar.ActionSYNC,
+					Node: grammar.NodeSync{
+						Source:      "./dist",
+						Destination: "/srv/app/public",
+						SourceCode:  "./dist /srv/app/public",
+					},
+				},
+				{
+					Key: grammar.ActionSYNC,
+					Node: grammar.NodeSync{
+						Source:      "./dist",
+						Destination: "/srv/app/public",
+						Delete:      true,
+						SourceCode:  "./dist /srv/app/public DELETE",
+					},
+				},
+				{
+					Key: grammar.ActionSYNC,
+					Node: grammar.NodeSync{
+						Source:      "./dist",
+						Destination: "/srv/app/public",
+						Delete:      true,
+						Checksum:    true,
+						SourceCode:  "./dist /srv/app/public CHECKSUM DELETE",
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "sync with invalid flag",
+			args: args{
+				input: `SYNC ./dist /srv/app/public FORCE`,
+			},
+			want:    []grammar.Token{},
+			wantErr: true,
+		},
+		{
+			name: "sync remote",
+			args: args{
+				input: `
+SYNC REMOTE /var/log/app ./logs APPEND
+SYNC REMOTE /srv/app/uploads ./uploads DELETE CHECKSUM
+`,
+			},
+			want: []grammar.Token{
+				{
+					Key: grammar.ActionSYNC,
+					Node: grammar.NodeSync{
+						Source:      "/var/log/app",
+						Destination: "./logs",
+						Remote:      true,
+						Append:      true,
+						SourceCode:  "REMOTE /var/log/app ./logs APPEND",
+					},
+				},
+				{
+					Key: grammar.ActionSYNC,
+					Node: grammar.NodeSync{
+						Source:      "/srv/app/uploads",
+						Destination: "./uploads",
+						Remote:      true,
+						Delete:      true,
+						Checksum:    true,
+						SourceCode:  "REMOTE /srv/app/uploads ./uploads DELETE CHECKSUM",
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "sync append without remote",
+			args: args{
+				input: `SYNC ./logs /var/log/app APPEND`,
+			},
+			want:    []grammar.Token{},
+			wantErr: true,
+		},
+		{
+			name: "upload, download and delete with exclude",
+			args: args{
+				input: `
+UPLOAD ./dist/**/*.js ./static EXCLUDE *.min.js
+UPLOAD ./app /srv EXCLUDE node_modules .git *.map
+DOWNLOAD /var/log/app/*.log ./logs
+DELETE /srv/app/releases/* EXCLUDE current
+`,
+			},
+			want: []grammar.Token{
+				{
+					Key: grammar.ActionUPLOAD,
+					Node: grammar.NodeUpload{
+						SourceFiles:    []string{"./dist/**/*.js"},
+						DestinationDir: "./static",
+						Exclude:        []string{"*.min.js"},
+						SourceCode:     "./dist/**/*.js ./static EXCLUDE *.min.js",
+					},
+				},
+				{
+					Key: grammar.ActionUPLOAD,
+					Node: grammar.NodeUpload{
+						SourceFiles:    []string{"./app"},
+						DestinationDir: "/srv",
+						Exclude:        []string{"node_modules", ".git", "*.map"},
+						SourceCode:     "./app /srv EXCLUDE node_modules .git *.map",
+					},
+				},
+				{
+					Key: grammar.ActionDOWNLOAD,
+					Node: grammar.NodeUpload{
+						SourceFiles:    []string{"/var/log/app/*.log"},
+						DestinationDir: "./logs",
+						SourceCode:     "/var/log/app/*.log ./logs",
+					},
+				},
+				{
+					Key: grammar.ActionDELETE,
+					Node: grammar.NodeDelete{
+						Targets:    []string{"/srv/app/releases/*"},
+						Exclude:    []string{"current"},
+						SourceCode: "/srv/app/releases/* EXCLUDE current",
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "exclude without pattern",
+			args: args{
+				input: `UPLOAD ./app /srv EXCLUDE`,
+			},
+			want:    []grammar.Token{},
+			wantErr: true,
+		},
+		{
+			name: "transfer as archive",
+			args: args{
+				input: `
+UPLOAD ./build /srv/app ARCHIVE
+DOWNLOAD /srv/app/uploads ./backup ARCHIVE EXCLUDE *.tmp
+SUDO UPLOAD ./build /srv/app ARCHIVE
+`,
+			},
+			want: []grammar.Token{
+				{
+					Key: grammar.ActionUPLOAD,
+					Node: grammar.NodeUpload{
+						SourceFiles:    []string{"./build"},
+						DestinationDir: "/srv/app",
+						Archive:        true,
+						SourceCode:     "./build /srv/app ARCHIVE",
+					},
+				},
+				{
+					Key: grammar.ActionDOWNLOAD,
+					Node: grammar.NodeUpload{
+						SourceFiles:    []string{"/srv/app/uploads"},
+						DestinationDir: "./backup",
+						Exclude:        []string{"*.tmp"},
+						Archive:        true,
+						SourceCode:     "/srv/app/uploads ./backup ARCHIVE EXCLUDE *.tmp",
+					},
+				},
+				{
+					Key: grammar.ActionUPLOAD,
+					Node: grammar.NodeUpload{
+						SourceFiles:    []string{"./build"},
+						DestinationDir: "/srv/app",
+						Archive:        true,
+						Sudo:           true,
+						SourceCode:     "./build /srv/app ARCHIVE",
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "verify and checksum",
+			args: args{
+				input: `
+UPLOAD ./release.tar.gz /srv/releases VERIFY
+DOWNLOAD /srv/app/uploads ./backup ARCHIVE VERIFY EXCLUDE *.tmp
+CHECKSUM /srv/releases/release.tar.gz SHA256 {{RELEASE_SHA256}}
+`,
+			},
+			want: []grammar.Token{
+				{
+					Key: grammar.ActionUPLOAD,
+					Node: grammar.NodeUpload{
+						SourceFiles:    []string{"./release.tar.gz"},
+						DestinationDir: "/srv/releases",
+						Verify:         true,
+						SourceCode:     "./release.tar.gz /srv/releases VERIFY",
+					},
+				},
+				{
+					Key: grammar.ActionDOWNLOAD,
+					Node: grammar.NodeUpload{
+						SourceFiles:    []string{"/srv/app/uploads"},
+						DestinationDir: "./backup",
+						Exclude:        []string{"*.tmp"},
+						Archive:        true,
+						Verify:         true,
+						SourceCode:     "/srv/app/uploads ./backup ARCHIVE VERIFY EXCLUDE *.tmp",
+					},
+				},
+				{
+					Key: grammar.ActionCHECKSUM,
+					Node: grammar.NodeChecksum{
+						Path:       "/srv/releases/release.tar.gz",
+						Algorithm:  "SHA256",
+						Expected:   "{{RELEASE_SHA256}}",
+						SourceCode: "/srv/releases/release.tar.gz SHA256 {{RELEASE_SHA256}}",
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "checksum with invalid algorithm",
+			args: args{
+				input: `CHECKSUM /srv/releases/release.tar.gz MD5 d41d8cd98f00b204e9800998ecf8427e`,
+			},
+			want:    []grammar.Token{},
+			wantErr: true,
+		},
+		{
+			name: "checksum without expected value",
+			args: args{
+				input: `CHECKSUM /srv/releases/release.tar.gz SHA256`,
+			},
+			want:    []grammar.Token{},
+			wantErr: true,
+		},
+		{
+			name: "transfer in parallel",
+			args: args{
+				input: `
+UPLOAD ./dist ./static /srv/app PARALLEL 8
+DOWNLOAD /var/log/app ./logs PARALLEL 4 VERIFY EXCLUDE *.gz
+`,
+			},
+			want: []grammar.Token{
+				{
+					Key: grammar.ActionUPLOAD,
+					Node: grammar.NodeUpload{
+						SourceFiles:    []string{"./dist", "./static"},
+						DestinationDir: "/srv/app",
+						Parallel:       8,
+						SourceCode:     "./dist ./static /srv/app PARALLEL 8",
+					},
+				},
+				{
+					Key: grammar.ActionDOWNLOAD,
+					Node: grammar.NodeUpload{
+						SourceFiles:    []string{"/var/log/app"},
+						DestinationDir: "./logs",
+						Exclude:        []string{"*.gz"},
+						Verify:         true,
+						Parallel:       4,
+						SourceCode:     "/var/log/app ./logs PARALLEL 4 VERIFY EXCLUDE *.gz",
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "parallel without number",
+			args: args{
+				input: `UPLOAD ./dist /srv/app PARALLEL`,
+			},
+			want:    []grammar.Token{},
+			wantErr: true,
+		},
+		{
+			name: "parallel with invalid number",
+			args: args{
+				input: `UPLOAD ./dist /srv/app PARALLEL 0`,
+			},
+			want:    []grammar.Token{},
+			wantErr: true,
+		},
+		{
+			name: "transfer with preserve",
+			args: args{
+				input: `
+UPLOAD ./releases /srv/app PRESERVE times,owner,links
+DOWNLOAD /srv/app/current ./backup PRESERVE links PARALLEL 4 EXCLUDE *.log
+`,
+			},
+			want: []grammar.Token{
+				{
+					Key: grammar.ActionUPLOAD,
+					Node: grammar.NodeUpload{
+						SourceFiles:    []string{"./releases"},
+						DestinationDir: "/srv/app",
+						Preserve:       []string{"times", "owner", "links"},
+						SourceCode:     "./releases /srv/app PRESERVE times,owner,links",
+					},
+				},
+				{
+					Key: grammar.ActionDOWNLOAD,
+					Node: grammar.NodeUpload{
+						SourceFiles:    []string{"/srv/app/current"},
+						DestinationDir: "./backup",
+						Exclude:        []string{"*.log"},
+						Parallel:       4,
+						Preserve:       []string{"links"},
+						SourceCode:     "/srv/app/current ./backup PRESERVE links PARALLEL 4 EXCLUDE *.log",
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "preserve with invalid attribute",
+			args: args{
+				input: `UPLOAD ./releases /srv/app PRESERVE times,mode`,
+			},
+			want:    []grammar.Token{},
+			wantErr: true,
+		},
+		{
+			name: "transfer as file and overwrite",
+			args: args{
+				input: `
+UPLOAD ./build/app-linux AS /usr/local/bin/app MODE 0755 BACKUP
+UPLOAD ./nginx.conf /etc/nginx IF_NEWER
+DOWNLOAD /var/log/app.log AS ./logs/app.log NO_CLOBBER
+`,
+			},
+			want: []grammar.Token{
+				{
+					Key: grammar.ActionUPLOAD,
+					Node: grammar.NodeUpload{
+						SourceFiles:     []string{"./build/app-linux"},
+						DestinationFile: "/usr/local/bin/app",
+						Mode:            0755,
+						Backup:          true,
+						SourceCode:      "./build/app-linux AS /usr/local/bin/app MODE 0755 BACKUP",
+					},
+				},
+				{
+					Key: grammar.ActionUPLOAD,
+					Node: grammar.NodeUpload{
+						SourceFiles:    []string{"./nginx.conf"},
+						DestinationDir: "/etc/nginx",
+						IfNewer:        true,
+						SourceCode:     "./nginx.conf /etc/nginx IF_NEWER",
+					},
+				},
+				{
+					Key: grammar.ActionDOWNLOAD,
+					Node: grammar.NodeUpload{
+						SourceFiles:     []string{"/var/log/app.log"},
+						DestinationFile: "./logs/app.log",
+						NoClobber:       true,
+						SourceCode:      "/var/log/app.log AS ./logs/app.log NO_CLOBBER",
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "no clobber with backup",
+			args: args{
+				input: `UPLOAD ./nginx.conf /etc/nginx NO_CLOBBER BACKUP`,
+			},
+			want:    []grammar.Token{},
+			wantErr: true,
+		},
+		{
+			name: "as with multiple sources",
+			args: args{
+				input: `UPLOAD ./a ./b AS /srv/c`,
+			},
+			want:    []grammar.Token{},
+			wantErr: true,
+		},
+		{
+			name: "as without destination",
+			args: args{
+				input: `DOWNLOAD /var/log/app.log ./logs AS`,
+			},
+			want:    []grammar.Token{},
+			wantErr: true,
+		},
+		{
+			name: "archive as file",
+			args: args{
+				input: `UPLOAD ./dist AS /srv/app ARCHIVE`,
+			},
+			want:    []grammar.Token{},
+			wantErr: true,
+		},
+		{
+			name: "transfer with invalid mode",
+			args: args{
+				input: `UPLOAD ./nginx.conf /etc/nginx MODE 644x`,
+			},
+			want:    []grammar.Token{},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -3,10 +3,15 @@ package host
 import (
 	"errors"
 	"fmt"
+	"os"
 	"regexp"
 	"strings"
 )
 
+const (
+	DefaultPort = "22"
+)
+
 var (
 	ConnectTypePassword       = "PASSWORD"
 	ConnectTypePrivateKeyFile = "FILE"
@@ -25,25 +30,57 @@ type Address struct {
 }
 
 var (
-	addressReg = regexp.MustCompile(fmt.Sprintf("^([\\w-\\.]+)@([\\w\\.-]+):(\\d+)\\s*(WITH\\s+(%s)\\s+(.*))?$", strings.Join(ConnectTypes, "|")))
+	// The username to use if the address does not specify. If it is empty, use `$USER` instead
+	DefaultUsername = ""
+)
+
+var (
+	// [ssh://][username@]host[:port] [WITH [PASSWORD|FILE] [VALUE]]
+	// host can be a domain, IPv4, IPv6 with brackets eg. `[::1]` or IPv6 without port eg. `::1`
+	addressReg = regexp.MustCompile(fmt.Sprintf("^(ssh://)?(([\\w-\\.]+)@)?(\\[([0-9a-fA-F:\\.%%\\w]+)\\](:(\\d+))?|([\\w\\.-]+)(:(\\d+))?|([0-9a-fA-F]*:[0-9a-fA-F]*:[0-9a-fA-F:\\.]*))/?\\s*(WITH\\s+(%s)\\s+(.*))?$", strings.Join(ConnectTypes, "|")))
 )
 
+func defaultUsername() string {
+	if DefaultUsername != "" {
+		return DefaultUsername
+	}
+
+	if username := os.Getenv("USER"); username != "" {
+		return username
+	}
+
+	// for Windows
+	return os.Getenv("USERNAME")
+}
+
 func Parse(address string) (Address, error) {
 	addr := Address{}
 
 	matchers := addressReg.FindAllStringSubmatch(address, -1)
 
 	if len(matchers) == 0 {
-		return addr, errors.New(fmt.Sprintf("address format should follow `<username>@<host>:<port> [WITH [%s] [VALUE]]` but got `%s`", strings.Join(ConnectTypes, "|"), address))
+		return addr, errors.New(fmt.Sprintf("address format should follow `[ssh://][<username>@]<host>[:<port>] [WITH [%s] [VALUE]]` but got `%s`", strings.Join(ConnectTypes, "|"), address))
 	}
 
 	matcher := matchers[0]
 
-	username := matcher[1]
-	host := matcher[2]
-	port := matcher[3]
-	connectType := matcher[5]
-	password := matcher[6]
+	username := matcher[3]
+	host := matcher[5] + matcher[8] + matcher[11]
+	port := matcher[7] + matcher[10]
+	connectType := matcher[13]
+	password := matcher[14]
+
+	if username == "" {
+		username = defaultUsername()
+	}
+
+	if username == "" {
+		return addr, errors.New(fmt.Sprintf("can not find username for address `%s`", address))
+	}
+
+	if port == "" {
+		port = DefaultPort
+	}
 
 	addr.Host = host
 	addr.Port = port
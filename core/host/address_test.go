@@ -1,9 +1,11 @@
 package host_test
 
 import (
-	"github.com/axetroy/s4/core/host"
+	"os"
 	"reflect"
 	"testing"
+
+	"github.com/axetroy/s4/core/host"
 )
 
 func TestParseAddress(t *testing.T) {
@@ -13,6 +15,10 @@ func TestParseAddress(t *testing.T) {
 	password := "123123"
 	publicKeyFile := "./path/to/private/key/file"
 
+	defer os.Setenv("USER", os.Getenv("USER"))
+
+	_ = os.Setenv("USER", "axetroy")
+
 	tests := []struct {
 		name    string
 		args    args
@@ -31,17 +37,118 @@ func TestParseAddress(t *testing.T) {
 			},
 		},
 		{
-			name: "invalid-1",
+			name: "without username",
 			args: args{
-				address: "192.168.0.1:22",
+				address: "192.168.0.1:2222",
+			},
+			want: host.Address{
+				Host:     "192.168.0.1",
+				Port:     "2222",
+				Username: "axetroy",
 			},
-			wantErr: true,
 		},
 		{
-			name: "invalid-2",
+			name: "without username and port",
 			args: args{
 				address: "192.168.0.1",
 			},
+			want: host.Address{
+				Host:     "192.168.0.1",
+				Port:     "22",
+				Username: "axetroy",
+			},
+		},
+		{
+			name: "without port",
+			args: args{
+				address: "root@example.com",
+			},
+			want: host.Address{
+				Host:     "example.com",
+				Port:     "22",
+				Username: "root",
+			},
+		},
+		{
+			name: "IPv6",
+			args: args{
+				address: "root@[2001:db8::1]:2222",
+			},
+			want: host.Address{
+				Host:     "2001:db8::1",
+				Port:     "2222",
+				Username: "root",
+			},
+		},
+		{
+			name: "IPv6 without port",
+			args: args{
+				address: "root@[2001:db8::1]",
+			},
+			want: host.Address{
+				Host:     "2001:db8::1",
+				Port:     "22",
+				Username: "root",
+			},
+		},
+		{
+			name: "IPv6 without brackets",
+			args: args{
+				address: "root@2001:db8::1",
+			},
+			want: host.Address{
+				Host:     "2001:db8::1",
+				Port:     "22",
+				Username: "root",
+			},
+		},
+		{
+			name: "IPv6 loopback",
+			args: args{
+				address: "::1",
+			},
+			want: host.Address{
+				Host:     "::1",
+				Port:     "22",
+				Username: "axetroy",
+			},
+		},
+		{
+			name: "URL",
+			args: args{
+				address: "ssh://root@192.168.0.1:2222",
+			},
+			want: host.Address{
+				Host:     "192.168.0.1",
+				Port:     "2222",
+				Username: "root",
+			},
+		},
+		{
+			name: "URL with IPv6 and password",
+			args: args{
+				address: "ssh://root@[2001:db8::1]:2222 WITH PASSWORD 123123",
+			},
+			want: host.Address{
+				Host:        "2001:db8::1",
+				Port:        "2222",
+				Username:    "root",
+				ConnectType: &host.ConnectTypePassword,
+				Password:    &password,
+			},
+		},
+		{
+			name: "invalid IPv6",
+			args: args{
+				address: "root@[2001:db8::1:22",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid address",
+			args: args{
+				address: "root@192.168.0.1:22:22",
+			},
 			wantErr: true,
 		},
 		{
@@ -91,3 +198,28 @@ func TestParseAddress(t *testing.T) {
 		})
 	}
 }
+
+func TestParseAddressWithDefaultUsername(t *testing.T) {
+	defer func() {
+		host.DefaultUsername = ""
+	}()
+
+	host.DefaultUsername = "deploy"
+
+	got, err := host.Parse("192.168.0.1")
+
+	if err != nil {
+		t.Errorf("ParseAddress() error = %v", err)
+		return
+	}
+
+	want := host.Address{
+		Host:     "192.168.0.1",
+		Port:     "22",
+		Username: "deploy",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseAddress() = %v, want %v", got, want)
+	}
+}
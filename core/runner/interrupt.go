@@ -0,0 +1,113 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/fatih/color"
+)
+
+// the exit code when the workflow is interrupted. the same as the shell does for SIGINT
+const InterruptExitCode = 130
+
+// InterruptError is returned by Run() when the workflow is interrupted by Ctrl-C or SIGTERM
+type InterruptError struct {
+	Step int // the step which is running when interrupted
+}
+
+func (e *InterruptError) Error() string {
+	return fmt.Sprintf("interrupted at step %d", e.Step)
+}
+
+// listen SIGINT and SIGTERM. the first one interrupts the workflow, the second one quits immediately
+func (r *Runner) handleSignal() (stop func()) {
+	sig := make(chan os.Signal, 2)
+	done := make(chan struct{})
+
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case <-sig:
+		case <-done:
+			return
+		}
+
+		fmt.Println(color.YellowString("Interrupting... press Ctrl-C again to force quit"))
+
+		r.interrupt()
+
+		select {
+		case <-sig:
+		case <-done:
+			return
+		}
+
+		fmt.Println(color.RedString("Force quit"))
+
+		os.Exit(InterruptExitCode)
+	}()
+
+	return func() {
+		signal.Stop(sig)
+		close(done)
+	}
+}
+
+// stop the running local commands and remote sessions
+func (r *Runner) interrupt() {
+	atomic.StoreInt32(&r.interrupted, 1)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.ssh != nil {
+		r.ssh.Interrupt()
+	}
+
+	for c := range r.processes {
+		// os.Interrupt is not supported on windows
+		if err := c.Process.Signal(os.Interrupt); err != nil {
+			_ = c.Process.Kill()
+		}
+	}
+}
+
+func (r *Runner) isInterrupted() bool {
+	return atomic.LoadInt32(&r.interrupted) == 1
+}
+
+// run the local command. it will be interrupted with the workflow
+func (r *Runner) runLocal(c *exec.Cmd) error {
+	r.lock.Lock()
+
+	if r.isInterrupted() {
+		r.lock.Unlock()
+		return &InterruptError{Step: r.currentStep - 1}
+	}
+
+	if err := c.Start(); err != nil {
+		r.lock.Unlock()
+		return err
+	}
+
+	if r.processes == nil {
+		r.processes = map[*exec.Cmd]struct{}{}
+	}
+
+	r.processes[c] = struct{}{}
+
+	r.lock.Unlock()
+
+	err := c.Wait()
+
+	r.lock.Lock()
+	delete(r.processes, c)
+	r.lock.Unlock()
+
+	return err
+}
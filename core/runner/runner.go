@@ -2,16 +2,29 @@ package runner
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/AlecAivazis/survey/v2"
+	"github.com/axetroy/s4/core/diff"
+	"github.com/axetroy/s4/core/edit"
+	"github.com/axetroy/s4/core/glob"
 	"github.com/axetroy/s4/core/grammar"
 	"github.com/axetroy/s4/core/host"
 	"github.com/axetroy/s4/core/ssh"
@@ -20,17 +33,42 @@ import (
 )
 
 type Runner struct {
-	ssh         *ssh.Client       // current ssh client
-	totalStep   int               // total step
-	currentStep int               // current step
-	cwdLocal    string            // current working dir at local
-	tokens      []grammar.Token   // token from parsing
-	cwdRemote   string            // current remote working dir
-	env         map[string]string // env for remote
-	variable    map[string]string // var
+	ssh         *ssh.Client            // current ssh client
+	totalStep   int                    // total step
+	currentStep int                    // current step
+	cwdLocal    string                 // current working dir at local
+	tokens      []grammar.Token        // token from parsing
+	cwdRemote   string                 // current remote working dir
+	homeRemote  string                 // the home dir of remote user. use for resolving `~`
+	dirsLocal   []string               // the local dir stack of `LOCAL PUSHD`
+	dirsRemote  []string               // the remote dir stack of `PUSHD`
+	env         map[string]string      // env for remote
+	variable    map[string]string      // var
+	scopes      []scope                // the opened scopes which can be closed by `END`
+	options     Options                // the options of runner
+	password    *string                // the password to connect server
+	sudo        *string                // the password for sudo. nil means not checked yet
+	become      string                 // the user to run command as with `BECOME`
+	shell       []string               // the shell to wrap the remote command with `SHELL`
+	localEnv    map[string]string      // env for local commands which set by `LOCAL ENV`
+	localShell  []string               // the shell to run local command with `LOCAL RUN`
+	interrupted int32                  // whether the workflow is interrupted by signal
+	processes   map[*exec.Cmd]struct{} // the running local commands
+	lock        sync.Mutex             // protect ssh and processes which are accessed by signal handler
 }
 
-func NewRunner(configFilePath string) (*Runner, error) {
+type Options struct {
+	KeepAlive       time.Duration // the interval of sending keepalive request to server, zero means disable
+	TransferWorkers int           // the number of files to transfer concurrently by `UPLOAD` and `DOWNLOAD`
+}
+
+// scope is opened by an action and closed by `END` or the end of workflow
+type scope struct {
+	action string       // the action which open the scope
+	close  func() error // the function to close the scope
+}
+
+func NewRunner(configFilePath string, options Options) (*Runner, error) {
 	if f, err := os.Stat(configFilePath); err != nil {
 		msg := fmt.Sprintf("Config file `%s` not found. print 's4 --help' for help.", configFilePath)
 		return nil, errors.New(color.RedString(msg))
@@ -55,12 +93,22 @@ func NewRunner(configFilePath string) (*Runner, error) {
 		return nil, err
 	}
 
+	cwd, err := os.Getwd()
+
+	if err != nil {
+		return nil, err
+	}
+
 	return &Runner{
 		currentStep: 1,
 		totalStep:   len(tokens),
 		tokens:      tokens,
+		cwdLocal:    cwd,
 		env:         map[string]string{},
+		localEnv:    map[string]string{},
+		localShell:  defaultLocalShell(),
 		variable:    map[string]string{},
+		options:     options,
 	}, nil
 }
 
@@ -73,10 +121,16 @@ func (r *Runner) requireConnection() error {
 }
 
 func (r *Runner) resolveLocalPath(localPath string) string {
-	if path.IsAbs(localPath) {
+	if localPath == "~" || strings.HasPrefix(localPath, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			localPath = filepath.Join(home, localPath[1:])
+		}
+	}
+
+	if filepath.IsAbs(localPath) {
 		return localPath
 	} else {
-		return path.Join(r.cwdLocal, localPath)
+		return filepath.Join(r.cwdLocal, localPath)
 	}
 }
 
@@ -91,6 +145,10 @@ func (r *Runner) resolveLocalPaths(localPaths []string) []string {
 }
 
 func (r *Runner) resolveRemotePath(remotePath string) string {
+	if remotePath == "~" || strings.HasPrefix(remotePath, "~/") {
+		remotePath = path.Join(r.homeRemote, remotePath[1:])
+	}
+
 	if path.IsAbs(remotePath) {
 		return remotePath
 	} else {
@@ -108,6 +166,76 @@ func (r *Runner) resolveRemotePaths(remotePaths []string) []string {
 	return paths
 }
 
+// the options to run command at remote server
+func (r *Runner) remoteOptions(sudo bool) (ssh.Options, error) {
+	options := ssh.Options{CWD: r.cwdRemote, Env: r.env, Shell: r.shell}
+
+	if sudo || r.become != "" {
+		password, err := r.sudoPassword()
+
+		if err != nil {
+			return options, err
+		}
+
+		options.Sudo = &ssh.Sudo{User: r.become, Password: password}
+	}
+
+	return options, nil
+}
+
+// get the password for sudo. it only asks once.
+func (r *Runner) sudoPassword() (string, error) {
+	if r.sudo != nil {
+		return *r.sudo, nil
+	}
+
+	requirePassword, err := r.ssh.SudoRequirePassword()
+
+	if err != nil {
+		return "", err
+	}
+
+	password := ""
+
+	if requirePassword {
+		if r.password != nil {
+			password = *r.password
+		} else {
+			prompt := &survey.Password{
+				Message: "Please type the password for sudo",
+			}
+
+			if err := survey.AskOne(prompt, &password); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	r.sudo = &password
+
+	return password, nil
+}
+
+func (r *Runner) openScope(action string, close func() error) {
+	r.scopes = append(r.scopes, scope{action: action, close: close})
+}
+
+// close all the opened scopes in reverse order
+func (r *Runner) closeScopes() error {
+	var err error
+
+	for len(r.scopes) != 0 {
+		s := r.scopes[len(r.scopes)-1]
+		r.scopes = r.scopes[:len(r.scopes)-1]
+
+		if closeErr := s.close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
 func (r *Runner) nextStep(action string, msg string) {
 	fmt.Printf("Step %d/%d: %s %s\n", r.currentStep, r.totalStep, strings.ToUpper(action), msg)
 	r.currentStep++
@@ -122,7 +250,13 @@ func printTimeDiff(d1 time.Time, d2 time.Time) {
 }
 
 func (r *Runner) Run() error {
+	stop := r.handleSignal()
+
+	defer stop()
+
 	defer func() {
+		_ = r.closeScopes()
+
 		if r.ssh != nil {
 			_ = r.ssh.Disconnect()
 		}
@@ -131,43 +265,7 @@ func (r *Runner) Run() error {
 	d1 := time.Now()
 
 	for _, action := range r.tokens {
-		var err error
-		switch action.Key {
-		case grammar.ActionCONNECT:
-			err = r.actionConnect(action.Node.(grammar.NodeConnect))
-			break
-		case grammar.ActionVAR:
-			err = r.actionVar(action.Node.(grammar.NodeVar))
-			break
-		case grammar.ActionENV:
-			err = r.actionEnv(action.Node.(grammar.NodeEnv))
-			break
-		case grammar.ActionCD:
-			err = r.actionCd(action.Node.(grammar.NodeCd))
-			break
-		case grammar.ActionRUN:
-			err = r.actionRun(action.Node.(grammar.NodeRun))
-			break
-		case grammar.ActionMOVE:
-			err = r.actionMove(action.Node.(grammar.NodeCopy))
-			break
-		case grammar.ActionCOPY:
-			err = r.actionCopy(action.Node.(grammar.NodeCopy))
-			break
-		case grammar.ActionDELETE:
-			err = r.actionDelete(action.Node.(grammar.NodeDelete))
-			break
-		case grammar.ActionUPLOAD:
-			err = r.actionUpload(action.Node.(grammar.NodeUpload))
-			break
-		case grammar.ActionDOWNLOAD:
-			err = r.actionDownload(action.Node.(grammar.NodeUpload))
-			break
-		default:
-			err = fmt.Errorf("invalid action `%s`", action.Key)
-		}
-
-		if err != nil {
+		if err := r.runStep(action); err != nil {
 			printTimeDiff(d1, time.Now())
 			return err
 		}
@@ -178,15 +276,157 @@ func (r *Runner) Run() error {
 	return nil
 }
 
+// run the step. if the connection dropped, reconnect and retry the idempotent step
+func (r *Runner) runStep(action grammar.Token) error {
+	if action.Key != grammar.ActionCONNECT {
+		if err := r.ensureConnection(); err != nil {
+			return err
+		}
+	}
+
+	step := r.currentStep
+
+	if r.isInterrupted() {
+		return &InterruptError{Step: step}
+	}
+
+	err := r.runAction(action)
+
+	// the step may be succeed if it is interrupted at the end. stop anyway
+	if r.isInterrupted() {
+		return &InterruptError{Step: step}
+	}
+
+	if err == nil || r.ssh == nil || r.ssh.Alive() {
+		return err
+	}
+
+	if !isIdempotent(action) {
+		return fmt.Errorf("connection lost and step %d can not be retried: %s", step, err)
+	}
+
+	fmt.Println(color.YellowString(fmt.Sprintf("Connection lost: %s. retry step %d", err, step)))
+
+	if err := r.ssh.Reconnect(); err != nil {
+		return err
+	}
+
+	r.currentStep = step
+
+	return r.runAction(action)
+}
+
+// reconnect if the connection is dead
+func (r *Runner) ensureConnection() error {
+	if r.ssh == nil || r.ssh.Alive() {
+		return nil
+	}
+
+	fmt.Println(color.YellowString("Connection lost. reconnecting..."))
+
+	return r.ssh.Reconnect()
+}
+
+// whether the action can be retried after the connection dropped.
+// only the steps which do not change anything at remote server are retried, `RUN` is retried if it is marked `IDEMPOTENT`
+func isIdempotent(action grammar.Token) bool {
+	switch action.Key {
+	case grammar.ActionRUN:
+		return action.Node.(grammar.NodeRun).Idempotent
+	case grammar.ActionMKDIR:
+		// `MKDIR` fails if the dir exist
+		return action.Node.(grammar.NodeMkdir).Parents
+	case grammar.ActionVAR:
+		// the command of `VAR` may change something
+		return action.Node.(grammar.NodeVar).Command == nil
+	case grammar.ActionCD,
+		grammar.ActionLCD,
+		grammar.ActionENV,
+		grammar.ActionCHECKSUM,
+		grammar.ActionREAD,
+		grammar.ActionSHELL:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *Runner) runAction(action grammar.Token) error {
+	switch action.Key {
+	case grammar.ActionCONNECT:
+		return r.actionConnect(action.Node.(grammar.NodeConnect))
+	case grammar.ActionVAR:
+		return r.actionVar(action.Node.(grammar.NodeVar))
+	case grammar.ActionENV:
+		return r.actionEnv(action.Node.(grammar.NodeEnv))
+	case grammar.ActionCD:
+		return r.actionCd(action.Node.(grammar.NodeCd))
+	case grammar.ActionLCD:
+		return r.actionLcd(action.Node.(grammar.NodeCd))
+	case grammar.ActionPUSHD:
+		return r.actionPushd(action.Node.(grammar.NodeCd))
+	case grammar.ActionPOPD:
+		return r.actionPopd(action.Node.(grammar.NodePopd))
+	case grammar.ActionRUN:
+		return r.actionRun(action.Node.(grammar.NodeRun))
+	case grammar.ActionMOVE:
+		return r.actionMove(action.Node.(grammar.NodeCopy))
+	case grammar.ActionCOPY:
+		return r.actionCopy(action.Node.(grammar.NodeCopy))
+	case grammar.ActionDELETE:
+		return r.actionDelete(action.Node.(grammar.NodeDelete))
+	case grammar.ActionMKDIR:
+		return r.actionMkdir(action.Node.(grammar.NodeMkdir))
+	case grammar.ActionCHMOD:
+		return r.actionChmod(action.Node.(grammar.NodeChmod))
+	case grammar.ActionCHOWN:
+		return r.actionChown(action.Node.(grammar.NodeChown))
+	case grammar.ActionLINK:
+		return r.actionLink(action.Node.(grammar.NodeLink))
+	case grammar.ActionTOUCH:
+		return r.actionTouch(action.Node.(grammar.NodeTouch))
+	case grammar.ActionTEMPLATE:
+		return r.actionTemplate(action.Node.(grammar.NodeTemplate))
+	case grammar.ActionWRITE:
+		return r.actionWrite(action.Node.(grammar.NodeWrite))
+	case grammar.ActionAPPEND:
+		return r.actionAppend(action.Node.(grammar.NodeWrite))
+	case grammar.ActionREAD:
+		return r.actionRead(action.Node.(grammar.NodeRead))
+	case grammar.ActionLINEINFILE:
+		return r.actionLineInFile(action.Node.(grammar.NodeLineInFile))
+	case grammar.ActionREPLACE:
+		return r.actionReplace(action.Node.(grammar.NodeReplace))
+	case grammar.ActionUPLOAD:
+		return r.actionUpload(action.Node.(grammar.NodeUpload))
+	case grammar.ActionDOWNLOAD:
+		return r.actionDownload(action.Node.(grammar.NodeUpload))
+	case grammar.ActionSYNC:
+		return r.actionSync(action.Node.(grammar.NodeSync))
+	case grammar.ActionCHECKSUM:
+		return r.actionChecksum(action.Node.(grammar.NodeChecksum))
+	case grammar.ActionTUNNEL:
+		return r.actionTunnel(action.Node.(grammar.NodeTunnel))
+	case grammar.ActionBECOME:
+		return r.actionBecome(action.Node.(grammar.NodeBecome))
+	case grammar.ActionSHELL:
+		return r.actionShell(action.Node.(grammar.NodeShell))
+	case grammar.ActionEND:
+		return r.actionEnd(action.Node.(grammar.NodeEnd))
+	default:
+		return fmt.Errorf("invalid action `%s`", action.Key)
+	}
+}
+
 func (r *Runner) actionConnect(params grammar.NodeConnect) error {
-	r.nextStep(grammar.ActionCONNECT, color.GreenString(fmt.Sprintf("%s@%s:%s", params.Username, params.Host, params.Port)))
+	r.nextStep(grammar.ActionCONNECT, color.GreenString(fmt.Sprintf("%s@%s", params.Username, net.JoinHostPort(params.Host, params.Port))))
 
 	// if ssh client exist. disconnect first
 	if r.ssh != nil {
 		if err := r.ssh.Disconnect(); err != nil {
 			return err
 		}
-		r.ssh = nil
+		r.setSSH(nil)
 	}
 
 	var password = new(string)
@@ -226,28 +466,39 @@ func (r *Runner) actionConnect(params grammar.NodeConnect) error {
 		privateKey = nil
 	}
 
-	r.ssh = ssh.NewSSH()
+	r.password = password
+	r.sudo = nil
 
-	if err := r.ssh.Connect(params.Host, params.Port, params.Username, password, privateKey); err != nil {
-		r.ssh = nil
-		return err
-	}
+	client := ssh.NewSSH()
+
+	client.SetKeepAlive(r.options.KeepAlive)
 
-	if cwd, err := os.Getwd(); err != nil {
+	if err := client.Connect(params.Host, params.Port, params.Username, password, privateKey); err != nil {
 		return err
-	} else {
-		r.cwdLocal = cwd
 	}
 
+	r.setSSH(client)
+
+	// the initial working dir of sftp is the home dir of user
 	if remoteCwd, err := r.ssh.Pwd(); err != nil {
 		return err
 	} else {
 		r.cwdRemote = remoteCwd
+		r.homeRemote = remoteCwd
+		r.dirsRemote = nil
 	}
 
 	return nil
 }
 
+// replace the ssh client. it may be read by signal handler concurrently
+func (r *Runner) setSSH(client *ssh.Client) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.ssh = client
+}
+
 func (r *Runner) actionCd(params grammar.NodeCd) error {
 	if err := r.requireConnection(); err != nil {
 		return err
@@ -257,9 +508,103 @@ func (r *Runner) actionCd(params grammar.NodeCd) error {
 
 	r.nextStep(grammar.ActionCD, color.GreenString(dir))
 
-	targetPath := variable.Compile(dir, r.variable)
+	return r.changeRemoteDir(variable.Compile(dir, r.variable))
+}
+
+// change the remote working dir. the dir must exist
+func (r *Runner) changeRemoteDir(dir string) error {
+	targetPath := r.resolveRemotePath(dir)
+
+	if stat, err := r.ssh.Stat(targetPath); err != nil {
+		return fmt.Errorf("can not change dir to `%s`: %w", targetPath, err)
+	} else if !stat.IsDir() {
+		return fmt.Errorf("can not change dir to `%s`: not a directory", targetPath)
+	}
+
+	r.cwdRemote = targetPath
+
+	return nil
+}
+
+// change the local working dir. the dir must exist
+func (r *Runner) changeLocalDir(dir string) error {
+	targetPath := r.resolveLocalPath(dir)
+
+	if stat, err := os.Stat(targetPath); err != nil {
+		return fmt.Errorf("can not change dir to `%s`: %w", targetPath, err)
+	} else if !stat.IsDir() {
+		return fmt.Errorf("can not change dir to `%s`: not a directory", targetPath)
+	}
+
+	r.cwdLocal = targetPath
+
+	return nil
+}
+
+func (r *Runner) actionLcd(params grammar.NodeCd) error {
+	r.nextStep(grammar.ActionLCD, color.GreenString(params.Target))
+
+	return r.changeLocalDir(variable.Compile(params.Target, r.variable))
+}
+
+func (r *Runner) actionPushd(params grammar.NodeCd) error {
+	if params.Local {
+		r.nextStep(grammar.ActionLOCAL+" "+grammar.ActionPUSHD, color.GreenString(params.Target))
 
-	r.cwdRemote = r.resolveRemotePath(targetPath)
+		previous := r.cwdLocal
+
+		if err := r.changeLocalDir(variable.Compile(params.Target, r.variable)); err != nil {
+			return err
+		}
+
+		r.dirsLocal = append(r.dirsLocal, previous)
+
+		return nil
+	}
+
+	if err := r.requireConnection(); err != nil {
+		return err
+	}
+
+	r.nextStep(grammar.ActionPUSHD, color.GreenString(params.Target))
+
+	previous := r.cwdRemote
+
+	if err := r.changeRemoteDir(variable.Compile(params.Target, r.variable)); err != nil {
+		return err
+	}
+
+	r.dirsRemote = append(r.dirsRemote, previous)
+
+	return nil
+}
+
+func (r *Runner) actionPopd(params grammar.NodePopd) error {
+	if params.Local {
+		r.nextStep(grammar.ActionLOCAL+" "+grammar.ActionPOPD, "")
+
+		if len(r.dirsLocal) == 0 {
+			return errors.New("`LOCAL POPD` with empty directory stack")
+		}
+
+		r.cwdLocal = r.dirsLocal[len(r.dirsLocal)-1]
+		r.dirsLocal = r.dirsLocal[:len(r.dirsLocal)-1]
+
+		fmt.Println(color.GreenString(r.cwdLocal))
+
+		return nil
+	}
+
+	r.nextStep(grammar.ActionPOPD, "")
+
+	if len(r.dirsRemote) == 0 {
+		return errors.New("`POPD` with empty directory stack")
+	}
+
+	r.cwdRemote = r.dirsRemote[len(r.dirsRemote)-1]
+	r.dirsRemote = r.dirsRemote[:len(r.dirsRemote)-1]
+
+	fmt.Println(color.GreenString(r.cwdRemote))
 
 	return nil
 }
@@ -302,7 +647,17 @@ func (r *Runner) actionDelete(params grammar.NodeDelete) error {
 
 	args := variable.CompileArray(params.Targets, r.variable)
 
-	files := r.resolveRemotePaths(args)
+	targets, err := r.expandTransferFiles(r.resolveRemotePaths(args), "", glob.NewIgnore(params.Exclude), false)
+
+	if err != nil {
+		return err
+	}
+
+	var files []string
+
+	for _, target := range targets {
+		files = append(files, target.source)
+	}
 
 	if err := r.ssh.Delete(files...); err != nil {
 		return err
@@ -313,14 +668,14 @@ func (r *Runner) actionDelete(params grammar.NodeDelete) error {
 
 func (r *Runner) actionDownload(params grammar.NodeUpload) error {
 	sourceFiles := params.SourceFiles
-	destinationDir := params.DestinationDir
+	destination := transferDestination(params)
 
 	r.nextStep(
 		grammar.ActionDOWNLOAD,
 		fmt.Sprintf(
 			"%s to %s",
 			color.YellowString(strings.Join(sourceFiles, ", ")),
-			color.GreenString(destinationDir),
+			color.GreenString(destination),
 		),
 	)
 
@@ -329,128 +684,1003 @@ func (r *Runner) actionDownload(params grammar.NodeUpload) error {
 	}
 
 	sourceFiles = variable.CompileArray(sourceFiles, r.variable)
-	destinationDir = variable.Compile(destinationDir, r.variable)
+	destination = variable.Compile(destination, r.variable)
 
 	sourceFiles = r.resolveRemotePaths(sourceFiles)
-	destinationDir = r.resolveLocalPath(destinationDir)
+	destination = r.resolveLocalPath(destination)
 
-	for _, filePath := range sourceFiles {
-		if err := r.ssh.Download(filePath, destinationDir); err != nil {
-			return err
+	exclude := glob.NewIgnore(params.Exclude)
+
+	destinationDir := destination
+
+	if params.DestinationFile != "" {
+		destinationDir = filepath.Dir(destination)
+	}
+
+	files, err := r.expandTransferFiles(sourceFiles, destinationDir, exclude, false)
+
+	if err != nil {
+		return err
+	}
+
+	// DOWNLOAD /var/log/app.log AS ./logs/app.log
+	if params.DestinationFile != "" {
+		if len(files) != 1 {
+			return fmt.Errorf("`%s` requires one source file but got %d", grammar.FlagAS, len(files))
 		}
+
+		files[0].destination = destinationDir
+		files[0].name = filepath.Base(destination)
 	}
 
-	return nil
-}
+	downloadFiles := make([]ssh.TransferFile, 0, len(files))
 
-func (r *Runner) actionMove(params grammar.NodeCopy) error {
-	sourceFilepath := params.Source
-	destinationFilepath := params.Destination
+	for _, file := range files {
+		downloadFiles = append(downloadFiles, ssh.TransferFile{
+			Source:      file.source,
+			Destination: file.destination,
+			Name:        file.name,
+			Options:     transferOptions(exclude, params),
+		})
+	}
 
-	r.nextStep(
-		grammar.ActionMOVE,
-		fmt.Sprintf(
-			"%s to %s",
-			color.YellowString(sourceFilepath),
-			color.GreenString(destinationFilepath),
-		),
-	)
+	return r.ssh.DownloadFiles(downloadFiles, r.transferWorkers(params))
+}
 
-	if err := r.requireConnection(); err != nil {
-		return err
+// the number of files to transfer concurrently. `PARALLEL` takes precedence over `--transfer-workers`
+func (r *Runner) transferWorkers(params grammar.NodeUpload) int {
+	if params.Parallel > 0 {
+		return params.Parallel
 	}
 
-	sourceFilepath = variable.Compile(sourceFilepath, r.variable)
-	destinationFilepath = variable.Compile(destinationFilepath, r.variable)
+	return r.options.TransferWorkers
+}
 
-	sourceFilepath = r.resolveRemotePath(sourceFilepath)
-	destinationFilepath = r.resolveRemotePath(destinationFilepath)
+// the file to transfer and the dir to transfer it to
+type transferFile struct {
+	source      string
+	destination string
+	name        string // the file name in destination with `AS`. it is the name of source if empty
+}
 
-	if err := r.ssh.Move(sourceFilepath, destinationFilepath); err != nil {
-		return err
+// the destination of `UPLOAD` and `DOWNLOAD`. it is a file with `AS`, otherwise a dir
+func transferDestination(params grammar.NodeUpload) string {
+	if params.DestinationFile != "" {
+		return params.DestinationFile
 	}
 
-	return nil
+	return params.DestinationDir
 }
 
-func (r *Runner) actionRun(params grammar.NodeRun) error {
-	r.nextStep(grammar.ActionRUN, color.YellowString(params.SourceCode))
+// expand the glob patterns of source files. the matched files keep the dirs relative to the base of pattern.
+// the source files are local if local is true, otherwise they are remote
+func (r *Runner) expandTransferFiles(patterns []string, destinationDir string, exclude *glob.Ignore, local bool) ([]transferFile, error) {
+	var files []transferFile
 
-	isPipeCommand := len(params.Commands) > 1
-	var lastCommandStdout bytes.Buffer
+	for _, pattern := range patterns {
+		slashPattern := filepath.ToSlash(pattern)
 
-	for _, cmd := range params.Commands {
-		if cmd.RunInLocal {
-			command := variable.Compile(cmd.Command[0], r.variable)
-			args := variable.CompileArray(cmd.Command[1:], r.variable)
+		if !glob.HasMeta(slashPattern) {
+			files = append(files, transferFile{source: pattern, destination: destinationDir})
+			continue
+		}
 
-			c := exec.Command(command, args...)
+		var matches []string
+		var err error
 
-			c.Stdin = bytes.NewReader(lastCommandStdout.Bytes())
-			c.Stdout = os.Stdout
-			c.Stderr = os.Stderr
+		if local {
+			matches, err = glob.Glob(pattern)
+		} else {
+			matches, err = r.ssh.Glob(pattern)
+		}
 
-			if err := c.Run(); err != nil {
-				return err
-			}
+		if err != nil {
+			return nil, err
+		}
 
-			if c.ProcessState.Success() == false {
-				return fmt.Errorf("run command '%v' fail", params.SourceCode)
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no such file matches `%s`", pattern)
+		}
+
+		for _, match := range matches {
+			var stat os.FileInfo
+
+			if local {
+				stat, err = os.Stat(match)
+			} else {
+				stat, err = r.ssh.Stat(match)
 			}
-		} else {
-			if err := r.requireConnection(); err != nil {
-				return err
+
+			if err != nil {
+				return nil, err
 			}
 
-			command := variable.Compile(cmd.SourceCode, r.variable)
+			rel := glob.Rel(slashPattern, filepath.ToSlash(match))
 
-			if stdout, _, err := r.ssh.Run(command, ssh.Options{CWD: r.cwdRemote, Env: r.env}); err != nil {
-				return err
+			if exclude.Match(rel, stat.IsDir()) {
+				continue
+			}
+
+			// upload to the remote dir, or download to the local dir
+			if local {
+				files = append(files, transferFile{source: match, destination: path.Join(destinationDir, path.Dir(rel))})
 			} else {
-				if isPipeCommand {
-					lastCommandStdout = stdout
-				}
+				files = append(files, transferFile{source: match, destination: filepath.Join(destinationDir, filepath.FromSlash(path.Dir(rel)))})
 			}
 		}
 	}
 
-	return nil
+	return files, nil
 }
 
-func (r *Runner) actionUpload(params grammar.NodeUpload) error {
-	sourceFiles := params.SourceFiles
-	destinationDir := params.DestinationDir
+// the options to upload the file. the `.s4ignore` in the dir to upload is honoured
+func uploadOptions(file string, params grammar.NodeUpload) (ssh.TransferOptions, error) {
+	ignore := glob.NewIgnore(nil)
 
-	r.nextStep(
-		grammar.ActionUPLOAD,
-		fmt.Sprintf(
-			"%s to %s",
-			color.YellowString(strings.Join(sourceFiles, ", ")),
-			color.GreenString(destinationDir),
-		),
-	)
+	if stat, err := os.Stat(file); err == nil && stat.IsDir() {
+		lines, err := glob.ReadIgnoreFile(filepath.Join(file, glob.IgnoreFile))
 
-	if err := r.requireConnection(); err != nil {
-		return err
+		if err != nil {
+			return ssh.TransferOptions{}, err
+		}
+
+		ignore.Add("/" + glob.IgnoreFile)
+		ignore.Add(lines...)
 	}
 
-	sourceFiles = variable.CompileArray(sourceFiles, r.variable)
-	destinationDir = variable.Compile(destinationDir, r.variable)
+	// `EXCLUDE` takes precedence over `.s4ignore`
+	ignore.Add(params.Exclude...)
 
-	sourceFiles = r.resolveLocalPaths(sourceFiles)
-	destinationDir = r.resolveRemotePath(destinationDir)
+	return transferOptions(ignore, params), nil
+}
 
-	for _, filePath := range sourceFiles {
-		if err := r.ssh.Upload(filePath, destinationDir); err != nil {
-			return err
+// the options of `UPLOAD` and `DOWNLOAD` with the files to skip
+func transferOptions(ignore *glob.Ignore, params grammar.NodeUpload) ssh.TransferOptions {
+	return ssh.TransferOptions{
+		Ignore:    ignore,
+		Archive:   params.Archive,
+		Verify:    params.Verify,
+		Preserve:  transferPreserve(params.Preserve),
+		Mode:      params.Mode,
+		IfNewer:   params.IfNewer,
+		NoClobber: params.NoClobber,
+		Backup:    params.Backup,
+	}
+}
+
+// the attributes to keep of `PRESERVE times,owner,links`
+func transferPreserve(attributes []string) ssh.Preserve {
+	var preserve ssh.Preserve
+
+	for _, attribute := range attributes {
+		switch attribute {
+		case grammar.PreserveTIMES:
+			preserve.Times = true
+		case grammar.PreserveOWNER:
+			preserve.Owner = true
+		case grammar.PreserveLINKS:
+			preserve.Links = true
 		}
 	}
 
-	return nil
+	return preserve
 }
 
-func (r *Runner) actionEnv(params grammar.NodeEnv) error {
-	r.nextStep(grammar.ActionENV, color.GreenString(params.SourceCode))
+func (r *Runner) actionSync(params grammar.NodeSync) error {
+	action := grammar.ActionSYNC
+
+	if params.Remote {
+		action += " " + grammar.FlagREMOTE
+	}
+
+	r.nextStep(
+		action,
+		fmt.Sprintf(
+			"%s to %s",
+			color.YellowString(params.Source),
+			color.GreenString(params.Destination),
+		),
+	)
+
+	if err := r.requireConnection(); err != nil {
+		return err
+	}
+
+	source := variable.Compile(params.Source, r.variable)
+	destination := variable.Compile(params.Destination, r.variable)
+	options := ssh.SyncOptions{
+		Delete:   params.Delete,
+		Checksum: params.Checksum,
+		Append:   params.Append,
+	}
+
+	var result ssh.SyncResult
+	var err error
+
+	if params.Remote {
+		result, err = r.ssh.SyncRemote(r.resolveRemotePath(source), r.resolveLocalPath(destination), options)
+	} else {
+		result, err = r.ssh.Sync(r.resolveLocalPath(source), r.resolveRemotePath(destination), options)
+	}
+
+	for _, file := range result.Added {
+		fmt.Println(color.GreenString("+ %s", file))
+	}
+
+	for _, file := range result.Changed {
+		fmt.Println(color.YellowString("~ %s", file))
+	}
+
+	for _, file := range result.Removed {
+		fmt.Println(color.RedString("- %s", file))
+	}
+
+	for _, e := range result.Failed {
+		fmt.Println(color.RedString("! %s", e.Error()))
+	}
+
+	// the summary is useless if it stop halfway
+	if len(result.Failed) == 0 && err != nil {
+		return err
+	}
+
+	fmt.Println(result.String())
+
+	return err
+}
+
+func (r *Runner) actionChecksum(params grammar.NodeChecksum) error {
+	if err := r.requireConnection(); err != nil {
+		return err
+	}
+
+	r.nextStep(grammar.ActionCHECKSUM, color.GreenString(params.SourceCode))
+
+	target := r.resolveRemotePath(variable.Compile(params.Path, r.variable))
+	expected := variable.Compile(params.Expected, r.variable)
+
+	if b, err := hex.DecodeString(expected); err != nil || len(b) != sha256.Size {
+		return fmt.Errorf("invalid %s checksum `%s`", params.Algorithm, expected)
+	}
+
+	if err := r.ssh.VerifyChecksum(target, expected); err != nil {
+		return err
+	}
+
+	fmt.Println(color.GreenString("%s: OK", target))
+
+	return nil
+}
+
+func (r *Runner) actionMove(params grammar.NodeCopy) error {
+	sourceFilepath := params.Source
+	destinationFilepath := params.Destination
+
+	r.nextStep(
+		grammar.ActionMOVE,
+		fmt.Sprintf(
+			"%s to %s",
+			color.YellowString(sourceFilepath),
+			color.GreenString(destinationFilepath),
+		),
+	)
+
+	if err := r.requireConnection(); err != nil {
+		return err
+	}
+
+	sourceFilepath = variable.Compile(sourceFilepath, r.variable)
+	destinationFilepath = variable.Compile(destinationFilepath, r.variable)
+
+	sourceFilepath = r.resolveRemotePath(sourceFilepath)
+	destinationFilepath = r.resolveRemotePath(destinationFilepath)
+
+	if err := r.ssh.Move(sourceFilepath, destinationFilepath); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *Runner) actionMkdir(params grammar.NodeMkdir) error {
+	if err := r.requireConnection(); err != nil {
+		return err
+	}
+
+	r.nextStep(grammar.ActionMKDIR, color.GreenString(params.SourceCode))
+
+	dirs := r.resolveRemotePaths(variable.CompileArray(params.Targets, r.variable))
+
+	for _, dir := range dirs {
+		if err := r.ssh.Mkdir(dir, params.Parents); err != nil {
+			return fmt.Errorf("create dir `%s` fail: %w", dir, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *Runner) actionChmod(params grammar.NodeChmod) error {
+	if err := r.requireConnection(); err != nil {
+		return err
+	}
+
+	r.nextStep(grammar.ActionCHMOD, color.GreenString(params.SourceCode))
+
+	target := r.resolveRemotePath(variable.Compile(params.Target, r.variable))
+
+	return r.ssh.Chmod(target, params.Mode, params.Recursive)
+}
+
+func (r *Runner) actionChown(params grammar.NodeChown) error {
+	if err := r.requireConnection(); err != nil {
+		return err
+	}
+
+	r.nextStep(grammar.ActionCHOWN, color.GreenString(params.SourceCode))
+
+	target := r.resolveRemotePath(variable.Compile(params.Target, r.variable))
+
+	return r.ssh.Chown(target, params.Owner, params.Group, params.Recursive)
+}
+
+func (r *Runner) actionLink(params grammar.NodeLink) error {
+	if err := r.requireConnection(); err != nil {
+		return err
+	}
+
+	r.nextStep(
+		grammar.ActionLINK,
+		fmt.Sprintf(
+			"%s to %s",
+			color.GreenString(params.Name),
+			color.YellowString(params.Target),
+		),
+	)
+
+	// the relative target is relative to the link. keep it as it is
+	target := variable.Compile(params.Target, r.variable)
+	name := r.resolveRemotePath(variable.Compile(params.Name, r.variable))
+
+	return r.ssh.Link(target, name)
+}
+
+func (r *Runner) actionTouch(params grammar.NodeTouch) error {
+	if err := r.requireConnection(); err != nil {
+		return err
+	}
+
+	r.nextStep(grammar.ActionTOUCH, color.GreenString(strings.Join(params.Targets, ", ")))
+
+	files := r.resolveRemotePaths(variable.CompileArray(params.Targets, r.variable))
+
+	for _, file := range files {
+		if err := r.ssh.Touch(file); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Runner) actionTemplate(params grammar.NodeTemplate) error {
+	if err := r.requireConnection(); err != nil {
+		return err
+	}
+
+	r.nextStep(
+		grammar.ActionTEMPLATE,
+		fmt.Sprintf(
+			"%s to %s",
+			color.YellowString(params.Source),
+			color.GreenString(params.Destination),
+		),
+	)
+
+	source := r.resolveLocalPath(variable.Compile(params.Source, r.variable))
+	destination := r.resolveRemotePath(variable.Compile(params.Destination, r.variable))
+
+	tmpl, err := ioutil.ReadFile(source)
+
+	if err != nil {
+		return err
+	}
+
+	content := variable.Compile(string(tmpl), r.variable)
+
+	// upload into the dir with the name of template. eg. app.conf.tmpl -> app.conf
+	if stat, err := r.ssh.Stat(destination); err == nil && stat.IsDir() {
+		destination = path.Join(destination, strings.TrimSuffix(filepath.Base(source), ".tmpl"))
+	}
+
+	previous, err := r.ssh.ReadFile(destination)
+
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	d := diff.Unified(destination, source, string(previous), content, 3)
+
+	// the file exists and nothing changed
+	if d == "" && err == nil {
+		fmt.Println(color.GreenString("`%s` is up to date", destination))
+
+		if params.Mode != 0 {
+			return r.ssh.Chmod(destination, params.Mode, false)
+		}
+
+		return nil
+	}
+
+	if d != "" {
+		printDiff(d)
+	}
+
+	return r.ssh.WriteFile(destination, []byte(content), params.Mode)
+}
+
+func (r *Runner) actionWrite(params grammar.NodeWrite) error {
+	if err := r.requireConnection(); err != nil {
+		return err
+	}
+
+	r.nextStep(grammar.ActionWRITE, color.GreenString(params.Path))
+
+	target := r.resolveRemotePath(variable.Compile(params.Path, r.variable))
+	content := variable.Compile(params.Content, r.variable)
+
+	return r.ssh.WriteFile(target, []byte(content), params.Mode)
+}
+
+func (r *Runner) actionAppend(params grammar.NodeWrite) error {
+	if err := r.requireConnection(); err != nil {
+		return err
+	}
+
+	r.nextStep(grammar.ActionAPPEND, color.GreenString(params.Path))
+
+	target := r.resolveRemotePath(variable.Compile(params.Path, r.variable))
+	content := variable.Compile(params.Content, r.variable)
+
+	if !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+
+	return r.ssh.AppendFile(target, []byte(content))
+}
+
+func (r *Runner) actionRead(params grammar.NodeRead) error {
+	if err := r.requireConnection(); err != nil {
+		return err
+	}
+
+	r.nextStep(grammar.ActionREAD, color.GreenString(params.SourceCode))
+
+	target := r.resolveRemotePath(variable.Compile(params.Path, r.variable))
+
+	content, err := r.ssh.ReadFile(target)
+
+	if err != nil {
+		return err
+	}
+
+	r.variable[params.Key] = strings.TrimRight(string(content), "\r\n")
+
+	return nil
+}
+
+func (r *Runner) actionLineInFile(params grammar.NodeLineInFile) error {
+	if err := r.requireConnection(); err != nil {
+		return err
+	}
+
+	r.nextStep(grammar.ActionLINEINFILE, color.GreenString(params.SourceCode))
+
+	target := r.resolveRemotePath(variable.Compile(params.Path, r.variable))
+	line := variable.Compile(params.Line, r.variable)
+
+	var match *regexp.Regexp
+
+	if params.Match != "" {
+		m, err := regexp.Compile(variable.Compile(params.Match, r.variable))
+
+		if err != nil {
+			return err
+		}
+
+		match = m
+	}
+
+	// create the file if not exist
+	return r.editRemoteFile(target, true, func(content string) (string, bool) {
+		return edit.LineInFile(content, line, match)
+	})
+}
+
+func (r *Runner) actionReplace(params grammar.NodeReplace) error {
+	if err := r.requireConnection(); err != nil {
+		return err
+	}
+
+	r.nextStep(grammar.ActionREPLACE, color.GreenString(params.SourceCode))
+
+	target := r.resolveRemotePath(variable.Compile(params.Path, r.variable))
+	replacement := variable.Compile(params.Replacement, r.variable)
+
+	// `^` and `$` match the begin and end of line
+	pattern, err := regexp.Compile("(?m)" + variable.Compile(params.Pattern, r.variable))
+
+	if err != nil {
+		return err
+	}
+
+	return r.editRemoteFile(target, false, func(content string) (string, bool) {
+		return edit.Replace(content, pattern, replacement)
+	})
+}
+
+// read the remote file, edit it and write it back atomically if changed
+func (r *Runner) editRemoteFile(target string, create bool, fn func(content string) (string, bool)) error {
+	content, err := r.ssh.ReadFile(target)
+
+	if err != nil && !(create && os.IsNotExist(err)) {
+		return err
+	}
+
+	result, changed := fn(string(content))
+
+	if !changed {
+		fmt.Println(color.GreenString("`%s` is unchanged", target))
+		return nil
+	}
+
+	if d := diff.Unified(target, target, string(content), result, 3); d != "" {
+		printDiff(d)
+	}
+
+	if err := r.ssh.WriteFileAtomic(target, []byte(result)); err != nil {
+		return err
+	}
+
+	fmt.Println(color.YellowString("`%s` is changed", target))
+
+	return nil
+}
+
+// print the diff in color
+func printDiff(d string) {
+	for _, line := range strings.Split(strings.TrimSuffix(d, "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+			fmt.Println(line)
+		case strings.HasPrefix(line, "@@"):
+			fmt.Println(color.CyanString(line))
+		case strings.HasPrefix(line, "+"):
+			fmt.Println(color.GreenString(line))
+		case strings.HasPrefix(line, "-"):
+			fmt.Println(color.RedString(line))
+		default:
+			fmt.Println(line)
+		}
+	}
+}
+
+func (r *Runner) actionRun(params grammar.NodeRun) error {
+	if params.Commands[0].Shell {
+		r.nextStep(grammar.ActionLOCAL+" "+grammar.ActionRUN, color.YellowString(params.SourceCode))
+	} else {
+		r.nextStep(grammar.ActionRUN, color.YellowString(params.SourceCode))
+	}
+
+	var (
+		stdoutBuf bytes.Buffer
+		stderrBuf bytes.Buffer
+		stdout    io.Writer // capture the stdout for REGISTER
+		stderr    io.Writer // capture the stderr for REGISTER
+	)
+
+	if params.Register != "" {
+		stdout = &stdoutBuf
+		stderr = &stderrBuf
+	}
+
+	startAt := time.Now()
+
+	var err error
+
+	if len(params.Commands) > 1 {
+		err = r.runPipe(params, stdout, stderr)
+	} else {
+		err = r.runCommand(params, stdout, stderr)
+	}
+
+	code, exited := exitCode(err)
+
+	if params.Register != "" {
+		r.variable[params.Register+".code"] = strconv.Itoa(code)
+		r.variable[params.Register+".stdout"] = strings.TrimSpace(stdoutBuf.String())
+		r.variable[params.Register+".stderr"] = strings.TrimSpace(stderrBuf.String())
+		r.variable[params.Register+".duration"] = fmt.Sprintf("%.3f", time.Since(startAt).Seconds())
+	}
+
+	// the command exit with non-zero code. but it is allowed
+	if err != nil && exited && params.AllowFail {
+		fmt.Println(color.YellowString(fmt.Sprintf("Command exit with code %d. ignored by %s", code, grammar.FlagALLOWFAIL)))
+		return nil
+	}
+
+	return err
+}
+
+// get the exit code from the error of command.
+// the second value is false if the command does not exit normally. eg. the connection dropped
+func exitCode(err error) (int, bool) {
+	if err == nil {
+		return 0, true
+	}
+
+	var exitErr *exec.ExitError
+
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), true
+	}
+
+	if code, ok := ssh.ExitCode(err); ok {
+		return code, true
+	}
+
+	return -1, false
+}
+
+// write to the output and capture it if capture is not nil
+func tee(output io.Writer, capture io.Writer) io.Writer {
+	if capture == nil {
+		return output
+	}
+
+	return io.MultiWriter(output, capture)
+}
+
+// run a single command at local or remote
+func (r *Runner) runCommand(params grammar.NodeRun, stdoutCapture io.Writer, stderrCapture io.Writer) error {
+	cmd := params.Commands[0]
+
+	if cmd.RunInLocal {
+		c := r.localCommand(cmd)
+
+		if params.Tty {
+			c.Stdin = os.Stdin
+		}
+
+		stdout, err := r.openOutput(cmd)
+
+		if err != nil {
+			return err
+		}
+
+		defer stdout.Close()
+
+		if cmd.Output == "" {
+			c.Stdout = tee(stdout, stdoutCapture)
+		} else {
+			c.Stdout = stdout
+		}
+
+		c.Stderr = tee(os.Stderr, stderrCapture)
+
+		if err := r.runLocal(c); err != nil {
+			return err
+		}
+
+		if c.ProcessState.Success() == false {
+			return fmt.Errorf("run command '%v' fail", params.SourceCode)
+		}
+	} else {
+		if err := r.requireConnection(); err != nil {
+			return err
+		}
+
+		command := variable.Compile(cmd.SourceCode, r.variable)
+
+		options, err := r.remoteOptions(params.Sudo)
+
+		if err != nil {
+			return err
+		}
+
+		if params.Tty {
+			return r.ssh.RunInteractive(command, options)
+		}
+
+		stdout, stderr, err := r.ssh.Run(command, options)
+
+		if stdoutCapture != nil {
+			_, _ = stdout.WriteTo(stdoutCapture)
+		}
+
+		if stderrCapture != nil {
+			_, _ = stderr.WriteTo(stderrCapture)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// run the commands which connected by pipe. the stdout of command will be streamed into the stdin of next command.
+func (r *Runner) runPipe(params grammar.NodeRun, stdoutCapture io.Writer, stderrCapture io.Writer) error {
+	var (
+		stdin  io.Reader
+		errs   = make(chan error, len(params.Commands))
+		runs   = make([]func(stdin io.Reader, stdout io.Writer) error, len(params.Commands))
+		exited = make([]chan struct{}, len(params.Commands)) // closed when the command exit
+	)
+
+	for index := range exited {
+		exited[index] = make(chan struct{})
+	}
+
+	stderr := tee(os.Stderr, stderrCapture)
+
+	// resolve all the commands before starting any of them, so nothing is left blocking on the pipe if one fails
+	for index, cmd := range params.Commands {
+		if cmd.RunInLocal {
+			c := r.localCommand(cmd)
+
+			runs[index] = func(stdin io.Reader, stdout io.Writer) error {
+				c.Stdin = stdin
+				c.Stdout = stdout
+				c.Stderr = stderr
+				return r.runLocal(c)
+			}
+		} else {
+			if err := r.requireConnection(); err != nil {
+				return err
+			}
+
+			command := variable.Compile(cmd.SourceCode, r.variable)
+
+			options, err := r.remoteOptions(params.Sudo)
+
+			if err != nil {
+				return err
+			}
+
+			runs[index] = func(stdin io.Reader, stdout io.Writer) error {
+				return r.ssh.Stream(command, options, stdin, stdout, stderr)
+			}
+		}
+	}
+
+	output, err := r.openOutput(params.Commands[len(params.Commands)-1])
+
+	if err != nil {
+		return err
+	}
+
+	defer output.Close()
+
+	for index, cmd := range params.Commands {
+		var (
+			stdout     io.Writer
+			pipeReader *io.PipeReader
+			pipeWriter *io.PipeWriter
+		)
+
+		if index == len(params.Commands)-1 {
+			if cmd.Output == "" {
+				stdout = tee(output, stdoutCapture)
+			} else {
+				stdout = output
+			}
+		} else {
+			pipeReader, pipeWriter = io.Pipe()
+			stdout = pipeWriter
+		}
+
+		go func(index int, cmd grammar.NodeRunCommand, stdin io.Reader, stdout io.Writer, pipeWriter *io.PipeWriter) {
+			err := runs[index](stdin, stdout)
+
+			close(exited[index])
+
+			// notify the next command there is no more input
+			if pipeWriter != nil {
+				_ = pipeWriter.CloseWithError(err)
+			}
+
+			// the command exit. the previous command should not write anymore
+			if reader, ok := stdin.(*io.PipeReader); ok {
+				_ = reader.Close()
+			}
+
+			if err != nil && pipeWriter != nil {
+				select {
+				case <-exited[index+1]:
+					// the next command exit without reading all the output. eg. `head`. it is not an error.
+					err = nil
+				default:
+				}
+			}
+
+			if err != nil {
+				err = fmt.Errorf("run command '%s' fail: %w", cmd.SourceCode, err)
+			}
+
+			errs <- err
+		}(index, cmd, stdin, stdout, pipeWriter)
+
+		stdin = pipeReader
+	}
+
+	for range params.Commands {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+// create the local command. it does not set the stdin/stdout/stderr
+func (r *Runner) localCommand(cmd grammar.NodeRunCommand) *exec.Cmd {
+	var c *exec.Cmd
+
+	if cmd.Shell {
+		args := append(append([]string{}, r.localShell[1:]...), variable.Compile(cmd.SourceCode, r.variable))
+
+		c = exec.Command(r.localShell[0], args...)
+	} else {
+		command := variable.Compile(cmd.Command[0], r.variable)
+		args := variable.CompileArray(cmd.Command[1:], r.variable)
+
+		c = exec.Command(command, args...)
+	}
+
+	c.Dir = r.cwdLocal
+	c.Env = r.localEnviron()
+
+	return c
+}
+
+// the shell to run the local command if `LOCAL SHELL` not set
+func defaultLocalShell() []string {
+	if runtime.GOOS == "windows" {
+		return []string{"cmd", "/C"}
+	}
+
+	return []string{"sh", "-c"}
+}
+
+// the environment variables for local command. nil means inherit from current process
+func (r *Runner) localEnviron() []string {
+	if len(r.localEnv) == 0 {
+		return nil
+	}
+
+	var keys []string
+
+	for key := range r.localEnv {
+		keys = append(keys, key)
+	}
+
+	// keep the order stable
+	sort.Strings(keys)
+
+	environ := os.Environ()
+
+	for _, key := range keys {
+		environ = append(environ, key+"="+r.localEnv[key])
+	}
+
+	return environ
+}
+
+// open the stdout for the command. it is the file to redirect or the stdout of terminal
+func (r *Runner) openOutput(cmd grammar.NodeRunCommand) (io.WriteCloser, error) {
+	if cmd.Output == "" {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+
+	return os.Create(r.resolveLocalPath(variable.Compile(cmd.Output, r.variable)))
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func (r *Runner) actionUpload(params grammar.NodeUpload) error {
+	sourceFiles := params.SourceFiles
+	destination := transferDestination(params)
+
+	r.nextStep(
+		grammar.ActionUPLOAD,
+		fmt.Sprintf(
+			"%s to %s",
+			color.YellowString(strings.Join(sourceFiles, ", ")),
+			color.GreenString(destination),
+		),
+	)
+
+	sudo := params.Sudo || r.become != ""
+
+	// the files are copied by `cp` with sudo, it can not tell whether the destination is newer or exists
+	if sudo && (params.IfNewer || params.NoClobber || params.Backup) {
+		return fmt.Errorf("`%s` with sudo does not support `%s`, `%s` and `%s`", grammar.ActionUPLOAD, grammar.FlagIFNEWER, grammar.FlagNOCLOBBER, grammar.FlagBACKUP)
+	}
+
+	if err := r.requireConnection(); err != nil {
+		return err
+	}
+
+	sourceFiles = variable.CompileArray(sourceFiles, r.variable)
+	destination = variable.Compile(destination, r.variable)
+
+	sourceFiles = r.resolveLocalPaths(sourceFiles)
+	destination = r.resolveRemotePath(destination)
+
+	destinationDir := destination
+
+	if params.DestinationFile != "" {
+		destinationDir = path.Dir(destination)
+	}
+
+	files, err := r.expandTransferFiles(sourceFiles, destinationDir, glob.NewIgnore(params.Exclude), true)
+
+	if err != nil {
+		return err
+	}
+
+	// UPLOAD ./build/app-linux AS /usr/local/bin/app
+	if params.DestinationFile != "" {
+		if len(files) != 1 {
+			return fmt.Errorf("`%s` requires one source file but got %d", grammar.FlagAS, len(files))
+		}
+
+		files[0].destination = destinationDir
+		files[0].name = path.Base(destination)
+	}
+
+	uploadFiles := make([]ssh.TransferFile, 0, len(files))
+
+	for _, file := range files {
+		fileOptions, err := uploadOptions(file.source, params)
+
+		if err != nil {
+			return err
+		}
+
+		uploadFiles = append(uploadFiles, ssh.TransferFile{Source: file.source, Destination: file.destination, Name: file.name, Options: fileOptions})
+	}
+
+	// upload with sudo
+	if sudo {
+		options, err := r.remoteOptions(true)
+
+		if err != nil {
+			return err
+		}
+
+		return r.ssh.SudoUpload(uploadFiles, r.transferWorkers(params), options)
+	}
+
+	return r.ssh.UploadFiles(uploadFiles, r.transferWorkers(params))
+}
+
+func (r *Runner) actionEnv(params grammar.NodeEnv) error {
+	if params.Local {
+		r.nextStep(grammar.ActionLOCAL+" "+grammar.ActionENV, color.GreenString(params.SourceCode))
+		r.localEnv[params.Key] = variable.Compile(params.Value, r.variable)
+		return nil
+	}
+
+	r.nextStep(grammar.ActionENV, color.GreenString(params.SourceCode))
 	r.env[params.Key] = variable.Compile(params.Value, r.variable)
 	return nil
 }
@@ -462,12 +1692,18 @@ func (r *Runner) actionVar(params grammar.NodeVar) error {
 		r.variable[params.Key] = params.Literal.Value
 	} else if params.Env != nil {
 		if params.Env.Local {
-			r.variable[params.Key] = os.Getenv(variable.Compile(params.Env.Key, r.variable))
+			key := variable.Compile(params.Env.Key, r.variable)
+
+			if value, ok := r.localEnv[key]; ok {
+				r.variable[params.Key] = value
+			} else {
+				r.variable[params.Key] = os.Getenv(key)
+			}
 		} else {
 			if err := r.requireConnection(); err != nil {
 				return err
 			}
-			if remoteEnvValue, err := r.ssh.Env(variable.Compile(params.Env.Key, r.variable), ssh.Options{Env: r.env}); err != nil {
+			if remoteEnvValue, err := r.ssh.Env(variable.Compile(params.Env.Key, r.variable), ssh.Options{Env: r.env, Shell: r.shell}); err != nil {
 				return err
 			} else {
 				r.variable[params.Key] = remoteEnvValue
@@ -482,13 +1718,16 @@ func (r *Runner) actionVar(params grammar.NodeVar) error {
 
 			c := exec.Command(command, args...)
 
+			c.Dir = r.cwdLocal
+			c.Env = r.localEnviron()
+
 			var stdoutBuf bytes.Buffer
 			var stderrBuf bytes.Buffer
 
 			c.Stdout = &stdoutBuf
 			c.Stderr = &stderrBuf
 
-			if err := c.Run(); err != nil {
+			if err := r.runLocal(c); err != nil {
 				return err
 			}
 
@@ -502,10 +1741,13 @@ func (r *Runner) actionVar(params grammar.NodeVar) error {
 				return err
 			}
 
-			stdout, _, err := r.ssh.Run(strings.Join(params.Command.Command, " "), ssh.Options{
-				CWD: r.cwdRemote,
-				Env: r.env,
-			})
+			options, err := r.remoteOptions(false)
+
+			if err != nil {
+				return err
+			}
+
+			stdout, _, err := r.ssh.Run(strings.Join(params.Command.Command, " "), options)
 
 			if err != nil {
 				return err
@@ -519,3 +1761,103 @@ func (r *Runner) actionVar(params grammar.NodeVar) error {
 
 	return nil
 }
+
+func (r *Runner) actionTunnel(params grammar.NodeTunnel) error {
+	r.nextStep(grammar.ActionTUNNEL, color.GreenString(params.SourceCode))
+
+	if err := r.requireConnection(); err != nil {
+		return err
+	}
+
+	var (
+		tunnel *ssh.Tunnel
+		err    error
+	)
+
+	listenAddr := net.JoinHostPort("127.0.0.1", params.Port)
+	target := variable.Compile(params.Target, r.variable)
+
+	if params.Remote {
+		tunnel, err = r.ssh.RemoteForward(listenAddr, target)
+	} else {
+		tunnel, err = r.ssh.LocalForward(listenAddr, target)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	r.openScope(grammar.ActionTUNNEL, tunnel.Close)
+
+	return nil
+}
+
+func (r *Runner) actionBecome(params grammar.NodeBecome) error {
+	r.nextStep(grammar.ActionBECOME, color.GreenString(params.SourceCode))
+
+	if err := r.requireConnection(); err != nil {
+		return err
+	}
+
+	previous := r.become
+
+	r.become = variable.Compile(params.User, r.variable)
+
+	r.openScope(grammar.ActionBECOME, func() error {
+		r.become = previous
+		return nil
+	})
+
+	return nil
+}
+
+func (r *Runner) actionShell(params grammar.NodeShell) error {
+	if params.Local {
+		r.nextStep(grammar.ActionLOCAL+" "+grammar.ActionSHELL, color.GreenString(params.SourceCode))
+
+		previous := r.localShell
+
+		r.localShell = variable.CompileArray(params.Shell, r.variable)
+
+		r.openScope(grammar.ActionLOCAL+" "+grammar.ActionSHELL, func() error {
+			r.localShell = previous
+			return nil
+		})
+
+		return nil
+	}
+
+	r.nextStep(grammar.ActionSHELL, color.GreenString(params.SourceCode))
+
+	previous := r.shell
+
+	r.shell = variable.CompileArray(params.Shell, r.variable)
+
+	r.openScope(grammar.ActionSHELL, func() error {
+		r.shell = previous
+		return nil
+	})
+
+	return nil
+}
+
+func (r *Runner) actionEnd(params grammar.NodeEnd) error {
+	if len(r.scopes) == 0 {
+		r.nextStep(grammar.ActionEND, "")
+		return errors.New("`END` without any opened scope")
+	}
+
+	s := r.scopes[len(r.scopes)-1]
+
+	// `END TUNNEL` must close the block it names, so the blocks are not closed in the wrong order silently
+	if params.Action != "" && params.Action != s.action {
+		r.nextStep(grammar.ActionEND, color.GreenString(params.Action))
+		return fmt.Errorf("`%s %s` does not match the last opened block `%s`", grammar.ActionEND, params.Action, s.action)
+	}
+
+	r.scopes = r.scopes[:len(r.scopes)-1]
+
+	r.nextStep(grammar.ActionEND, color.GreenString(s.action))
+
+	return s.close()
+}
@@ -0,0 +1,178 @@
+package runner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/axetroy/s4/core/grammar"
+	"github.com/axetroy/s4/core/ssh/sshtest"
+)
+
+// create a runner which is connected to an in-process SSH server
+func newTestRunner(t *testing.T) (*Runner, *sshtest.Server, string, func()) {
+	server, err := sshtest.NewServer()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tempDir, err := ioutil.TempDir("", "s4_test_")
+
+	if err != nil {
+		_ = server.Close()
+		t.Fatal(err)
+	}
+
+	cleanup := func() {
+		_ = server.Close()
+		_ = os.RemoveAll(tempDir)
+	}
+
+	configFilePath := filepath.Join(tempDir, "s4file")
+
+	content := fmt.Sprintf("CONNECT test@%s:%s WITH PASSWORD test\n", server.Host, server.Port)
+
+	if err := ioutil.WriteFile(configFilePath, []byte(content), 0644); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	r, err := NewRunner(configFilePath, Options{})
+
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	if err := r.runStep(r.tokens[0]); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	return r, server, tempDir, func() {
+		_ = r.ssh.Disconnect()
+		cleanup()
+	}
+}
+
+// parse the only token of the s4 statement
+func parseTestToken(t *testing.T, input string) grammar.Token {
+	tokens, err := grammar.Tokenizer(input)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tokens) != 1 {
+		t.Fatalf("`%s` should be parsed into one token but got %d", input, len(tokens))
+	}
+
+	return tokens[0]
+}
+
+func TestIsIdempotent(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{input: "RUN apt-get update", want: false},
+		{input: "RUN apt-get update IDEMPOTENT", want: true},
+		{input: "MKDIR /srv/app", want: false},
+		{input: "MKDIR -p /srv/app", want: true},
+		{input: "VAR version = 1.0.0", want: true},
+		{input: "VAR version <= git describe", want: false},
+		{input: "CD /srv/app", want: true},
+		{input: "ENV NODE_ENV = production", want: true},
+		{input: "CHECKSUM /srv/app.tar.gz SHA256 e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", want: true},
+		{input: "UPLOAD ./dist /srv/app", want: false},
+		{input: "DOWNLOAD /var/log/app.log ./logs", want: false},
+		{input: "SYNC ./dist /srv/app", want: false},
+		{input: "DELETE /srv/app/cache", want: false},
+		{input: "WRITE /srv/app/.env NODE_ENV=production", want: false},
+		{input: "TUNNEL LOCAL 15432 TO localhost:5432", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if got := isIdempotent(parseTestToken(t, tt.input)); got != tt.want {
+				t.Errorf("isIdempotent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunStepReconnectBeforeStep(t *testing.T) {
+	r, server, dir, cleanup := newTestRunner(t)
+	defer cleanup()
+
+	target := path.Join(filepath.ToSlash(dir), "app")
+
+	server.Drop()
+
+	if err := r.runStep(parseTestToken(t, "MKDIR "+target)); err != nil {
+		t.Fatalf("runStep() error = %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "app")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRunStepRetry(t *testing.T) {
+	tests := []struct {
+		name      string
+		parents   bool
+		wantRetry bool
+	}{
+		{name: "idempotent step", parents: true, wantRetry: true},
+		{name: "not idempotent step", parents: false, wantRetry: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, server, dir, cleanup := newTestRunner(t)
+			defer cleanup()
+
+			input := "MKDIR " + path.Join(filepath.ToSlash(dir), "app")
+
+			if tt.parents {
+				input = "MKDIR -p " + path.Join(filepath.ToSlash(dir), "app")
+			}
+
+			// the connection is alive before the step, and dropped during the step
+			server.DropAfterKeepAlive()
+
+			err := r.runStep(parseTestToken(t, input))
+
+			_, statErr := os.Stat(filepath.Join(dir, "app"))
+
+			if tt.wantRetry {
+				if err != nil {
+					t.Fatalf("runStep() error = %v", err)
+				}
+
+				if statErr != nil {
+					t.Errorf("the step is not retried: %v", statErr)
+				}
+
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), "can not be retried") {
+				t.Fatalf("runStep() error = %v, want the step not retried", err)
+			}
+
+			if statErr == nil {
+				t.Error("the step should not be retried")
+			}
+
+			// the next step reconnects
+			if err := r.runStep(parseTestToken(t, input)); err != nil {
+				t.Errorf("runStep() error = %v after the connection dropped", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,539 @@
+package ssh
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cheggaaa/pb/v3"
+)
+
+// whether the command exist at remote server
+func (c *Client) hasCommand(name string) bool {
+	if c.sshClient == nil {
+		return false
+	}
+
+	return c.Stream("command -v "+shellQuote(name), Options{}, nil, ioutil.Discard, ioutil.Discard) == nil
+}
+
+// the local file to archive
+type archiveEntry struct {
+	name     string // the name in archive
+	filePath string
+	info     os.FileInfo
+	link     string // the target of symbolic link
+}
+
+// list the files to archive. the name in archive starts with the base name of localFilePath.
+// the symbolic links are kept if links is preserved, otherwise the links to file are followed and others are ignored
+func archiveEntries(localFilePath string, options TransferOptions) ([]archiveEntry, int64, error) {
+	var entries []archiveEntry
+	var size int64
+
+	root := filepath.Base(localFilePath)
+
+	err := filepath.Walk(localFilePath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(localFilePath, filePath)
+
+		if err != nil {
+			return err
+		}
+
+		rel = filepath.ToSlash(rel)
+
+		if rel != "." && options.Ignore.Match(rel, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 && options.Preserve.Links {
+			link, err := os.Readlink(filePath)
+
+			if err != nil {
+				return err
+			}
+
+			entries = append(entries, archiveEntry{name: path.Join(root, rel), filePath: filePath, info: info, link: filepath.ToSlash(link)})
+
+			return nil
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			if info, err = os.Stat(filePath); err != nil || !info.Mode().IsRegular() {
+				return nil
+			}
+		}
+
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+
+		entries = append(entries, archiveEntry{name: path.Join(root, rel), filePath: filePath, info: info})
+
+		return nil
+	})
+
+	return entries, size, err
+}
+
+// write the entries to w as tar.gz. the bar is increased by the size of file content.
+// the SHA-256 of files are put into sums by the name in archive if sums is not nil
+func (c *Client) writeArchive(w io.Writer, entries []archiveEntry, options TransferOptions, bar *pb.ProgressBar, sums map[string]string) error {
+	gzipWriter := gzip.NewWriter(w)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	for _, entry := range entries {
+		header, err := tar.FileInfoHeader(entry.info, entry.link)
+
+		if err != nil {
+			return err
+		}
+
+		header.Name = entry.name
+
+		// the files are owned by the user who extracts them, as the same as uploading by SFTP.
+		// the owner is kept by id if it is preserved
+		if !options.Preserve.Owner {
+			header.Uid = 0
+			header.Gid = 0
+		}
+
+		header.Uname = ""
+		header.Gname = ""
+
+		if options.Mode != 0 && entry.info.Mode().IsRegular() {
+			header.Mode = tarMode(options.Mode)
+		}
+
+		if entry.info.IsDir() {
+			header.Name += "/"
+		}
+
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if !entry.info.Mode().IsRegular() {
+			continue
+		}
+
+		var fileWriter io.Writer = tarWriter
+
+		hash := sha256.New()
+
+		if sums != nil {
+			fileWriter = io.MultiWriter(tarWriter, hash)
+		}
+
+		if err := c.copyArchiveFile(fileWriter, entry.filePath, bar); err != nil {
+			return err
+		}
+
+		if sums != nil {
+			sums[entry.name] = hex.EncodeToString(hash.Sum(nil))
+		}
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+
+	return gzipWriter.Close()
+}
+
+func (c *Client) copyArchiveFile(w io.Writer, filePath string, bar *pb.ProgressBar) error {
+	file, err := os.Open(filePath)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	_, err = io.Copy(w, bar.NewProxyReader(interruptReader{client: c, reader: file}))
+
+	return err
+}
+
+// extract the tar.gz to the local dir. the bar is increased by the size of file content.
+// the SHA-256 of files are put into sums by the name in archive if sums is not nil
+func (c *Client) extractArchive(r io.Reader, localDir string, options TransferOptions, bar *pb.ProgressBar, sums map[string]string) error {
+	gzipReader, err := gzip.NewReader(r)
+
+	if err != nil {
+		return err
+	}
+
+	defer gzipReader.Close()
+
+	tarReader := tar.NewReader(gzipReader)
+
+	for {
+		header, err := tarReader.Next()
+
+		if err == io.EOF {
+			// read the padding at the end, otherwise the writer will fail
+			_, err = io.Copy(ioutil.Discard, r)
+			return err
+		} else if err != nil {
+			return err
+		}
+
+		name := path.Clean(strings.TrimPrefix(header.Name, "./"))
+
+		// prevent writing the file outside of the dir
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("invalid file name '%s' in archive", header.Name)
+		}
+
+		// the first segment is the name of the dir to download
+		if index := strings.Index(name, "/"); index >= 0 && options.Ignore.Match(name[index+1:], header.Typeflag == tar.TypeDir) {
+			continue
+		}
+
+		// the links in archive may point to outside of the dir
+		if options.Preserve.Links && hasLinkParent(localDir, name) {
+			return fmt.Errorf("invalid file name '%s' in archive, its parent is a symbolic link", header.Name)
+		}
+
+		localFilePath := filepath.Join(localDir, filepath.FromSlash(name))
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(localFilePath, 0755); err != nil {
+				return err
+			}
+
+			if err := os.Chmod(localFilePath, header.FileInfo().Mode()); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if !options.Preserve.Links {
+				continue
+			}
+
+			if err := os.MkdirAll(filepath.Dir(localFilePath), 0755); err != nil {
+				return err
+			}
+
+			if err := replaceLocalLink(filepath.FromSlash(header.Linkname), localFilePath); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(localFilePath), 0755); err != nil {
+				return err
+			}
+
+			var fileReader io.Reader = tarReader
+
+			hash := sha256.New()
+
+			if sums != nil {
+				fileReader = io.TeeReader(tarReader, hash)
+			}
+
+			mode := header.FileInfo().Mode()
+
+			if options.Mode != 0 {
+				mode = options.Mode
+			}
+
+			if err := c.extractArchiveFile(fileReader, localFilePath, mode, bar); err != nil {
+				return err
+			}
+
+			if sums != nil {
+				sums[name] = hex.EncodeToString(hash.Sum(nil))
+			}
+
+			// the times of dir are changed by the files in it, so they are kept for files only
+			if options.Preserve.Times {
+				if err := os.Chtimes(localFilePath, header.ModTime, header.ModTime); err != nil {
+					return err
+				}
+			}
+		default:
+			continue
+		}
+
+		if options.Preserve.Owner {
+			if err := setLocalFileOwner(localFilePath, header.Uid, header.Gid); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (c *Client) extractArchiveFile(r io.Reader, localFilePath string, mode os.FileMode, bar *pb.ProgressBar) error {
+	file, err := os.OpenFile(localFilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	if _, err := io.Copy(file, bar.NewProxyReader(interruptReader{client: c, reader: r})); err != nil {
+		// remove the half-written file
+		_ = file.Close()
+		_ = os.Remove(localFilePath)
+		return err
+	}
+
+	// the mode of existing file is not changed by OpenFile
+	return os.Chmod(localFilePath, mode)
+}
+
+// upload the file or dir as tar.gz and extract it at remote server by `tar`.
+// it is extracted to a temp dir first, then moved into place, so the interrupted upload leaves nothing
+func (c *Client) uploadArchive(localFilePath string, remoteDir string, options TransferOptions) error {
+	entries, size, err := archiveEntries(localFilePath, options)
+
+	if err != nil {
+		return err
+	}
+
+	// the temp dir is in the dest dir, so the entries can be renamed into place
+	tempDir := path.Join(remoteDir, fmt.Sprintf(".%s.s4tmp%d", filepath.Base(localFilePath), time.Now().UnixNano()))
+
+	if err := c.sftpClient.MkdirAll(tempDir); err != nil {
+		return err
+	}
+
+	defer func() {
+		_, _ = c.runQuiet("rm -rf "+shellQuote(tempDir), Options{})
+	}()
+
+	bar := newProgressBar(path.Join(remoteDir, filepath.Base(localFilePath)), size)
+
+	var sums map[string]string
+
+	if options.Verify {
+		sums = map[string]string{}
+	}
+
+	reader, writer := io.Pipe()
+
+	result := make(chan error, 1)
+
+	go func() {
+		err := c.writeArchive(writer, entries, options, bar, sums)
+
+		_ = writer.CloseWithError(err)
+
+		result <- err
+	}()
+
+	var stderr bytes.Buffer
+
+	// `p` keeps the mode of files for the user who is not root
+	err = c.Stream("tar xzpf - -C "+shellQuote(tempDir), Options{}, reader, ioutil.Discard, &stderr)
+
+	// stop writing if tar exit
+	_ = reader.Close()
+
+	if writeErr := <-result; writeErr != nil && writeErr != io.ErrClosedPipe {
+		return writeErr
+	}
+
+	if err != nil {
+		return commandError(err, stderr)
+	}
+
+	if err := c.verifyArchive(tempDir, sums); err != nil {
+		return err
+	}
+
+	if err := c.moveArchive(tempDir, remoteDir, entries, options); err != nil {
+		return err
+	}
+
+	bar.Finish()
+
+	return nil
+}
+
+// move the extracted entries from the temp dir to the dest dir. the dirs which do not exist are moved as a whole,
+// the existing dirs are merged as `tar` does
+func (c *Client) moveArchive(tempDir string, remoteDir string, entries []archiveEntry, options TransferOptions) error {
+	// the last dir which is moved as a whole. the entries in it are moved with it
+	var movedDir string
+
+	for _, entry := range entries {
+		if movedDir != "" && strings.HasPrefix(entry.name, movedDir+"/") {
+			continue
+		}
+
+		source := path.Join(tempDir, entry.name)
+		destination := path.Join(remoteDir, entry.name)
+
+		if entry.info.IsDir() {
+			stat, err := c.sftpClient.Lstat(destination)
+
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+
+			if err == nil && stat.IsDir() {
+				// `tar` sets the mode of existing dir
+				if err := c.sftpClient.Chmod(destination, entry.info.Mode().Perm()); err != nil {
+					return err
+				}
+
+				if err := c.preserveRemote(destination, entry.info, Preserve{Owner: options.Preserve.Owner}); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			// the dir can not replace the file by renaming
+			if err == nil {
+				if err := c.sftpClient.Remove(destination); err != nil {
+					return err
+				}
+			}
+
+			movedDir = entry.name
+		}
+
+		if err := c.posixRename(source, destination); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// download the file or dir as tar.gz which is created at remote server by `tar`, and extract it to the local dir
+func (c *Client) downloadArchive(remoteFilePath string, localDir string, options TransferOptions) error {
+	var size int64
+
+	walker := c.sftpClient.Walk(remoteFilePath)
+
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return err
+		}
+
+		if walker.Stat().Mode().IsRegular() {
+			size += walker.Stat().Size()
+		}
+	}
+
+	if err := os.MkdirAll(localDir, 0755); err != nil {
+		return err
+	}
+
+	bar := newProgressBar(filepath.Join(localDir, path.Base(remoteFilePath)), size)
+
+	var sums map[string]string
+
+	if options.Verify {
+		sums = map[string]string{}
+	}
+
+	reader, writer := io.Pipe()
+
+	result := make(chan error, 1)
+
+	go func() {
+		err := c.extractArchive(reader, localDir, options, bar, sums)
+
+		// stop tar if extracting fail
+		_ = reader.CloseWithError(err)
+
+		result <- err
+	}()
+
+	var stderr bytes.Buffer
+
+	// `h` follows the symbolic links as the same as downloading by SFTP
+	flags := "czhf"
+
+	if options.Preserve.Links {
+		flags = "czf"
+	}
+
+	err := c.Stream(
+		fmt.Sprintf("tar %s - -C %s %s", flags, shellQuote(path.Dir(remoteFilePath)), shellQuote(path.Base(remoteFilePath))),
+		Options{},
+		nil,
+		writer,
+		&stderr,
+	)
+
+	_ = writer.Close()
+
+	if extractErr := <-result; extractErr != nil {
+		return extractErr
+	}
+
+	if err != nil {
+		return commandError(err, stderr)
+	}
+
+	if err := c.verifyArchive(path.Dir(remoteFilePath), sums); err != nil {
+		return err
+	}
+
+	bar.Finish()
+
+	return nil
+}
+
+// compare the SHA-256 of the files in archive with the files in the remote dir. nil sums means not verify
+func (c *Client) verifyArchive(remoteDir string, sums map[string]string) error {
+	if sums == nil {
+		return nil
+	}
+
+	remoteSums := make(map[string]string, len(sums))
+
+	for name, sum := range sums {
+		remoteSums[path.Join(remoteDir, name)] = sum
+	}
+
+	return c.verifyChecksums(remoteSums)
+}
+
+// the mode in tar header. the special bits of os.FileMode are not the same as unix
+func tarMode(mode os.FileMode) int64 {
+	result := int64(mode.Perm())
+
+	if mode&os.ModeSetuid != 0 {
+		result |= 04000
+	}
+
+	if mode&os.ModeSetgid != 0 {
+		result |= 02000
+	}
+
+	if mode&os.ModeSticky != 0 {
+		result |= 01000
+	}
+
+	return result
+}
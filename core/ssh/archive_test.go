@@ -0,0 +1,202 @@
+package ssh
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/axetroy/s4/core/glob"
+)
+
+func TestArchive(t *testing.T) {
+	c := &Client{}
+
+	dir, err := ioutil.TempDir("", "s4_test_")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	writeTestFiles(t, filepath.Join(dir, "build"), map[string]string{
+		"index.html":        "index",
+		"bin/start.sh":      "#!/bin/sh",
+		"app.js.map":        "map",
+		"node_modules/a.js": "a",
+	})
+
+	if err := os.Chmod(filepath.Join(dir, "build", "bin", "start.sh"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	options := TransferOptions{Ignore: glob.NewIgnore([]string{"node_modules"})}
+
+	entries, size, err := archiveEntries(filepath.Join(dir, "build"), options)
+
+	if err != nil {
+		t.Fatalf("archiveEntries() error = %v", err)
+	}
+
+	if size != int64(len("index")+len("#!/bin/sh")+len("map")) {
+		t.Errorf("archiveEntries() size = %d", size)
+	}
+
+	var buf bytes.Buffer
+
+	bar := newProgressBar("test", size)
+
+	writeSums := map[string]string{}
+
+	if err := c.writeArchive(&buf, entries, TransferOptions{}, bar, writeSums); err != nil {
+		t.Fatalf("writeArchive() error = %v", err)
+	}
+
+	// skip the file when extracting
+	options = TransferOptions{Ignore: glob.NewIgnore([]string{"*.map"})}
+
+	extractSums := map[string]string{}
+
+	if err := c.extractArchive(&buf, filepath.Join(dir, "local"), options, bar, extractSums); err != nil {
+		t.Fatalf("extractArchive() error = %v", err)
+	}
+
+	if len(writeSums) != 3 || len(extractSums) != 2 || writeSums["build/bin/start.sh"] != extractSums["build/bin/start.sh"] {
+		t.Errorf("checksums of archive = %v, %v", writeSums, extractSums)
+	}
+
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "local", "build", "bin", "start.sh")); err != nil || string(b) != "#!/bin/sh" {
+		t.Errorf("extractArchive() content = %q, %v", b, err)
+	}
+
+	if stat, err := os.Stat(filepath.Join(dir, "local", "build", "bin", "start.sh")); err != nil {
+		t.Error(err)
+	} else if runtime.GOOS != "windows" && stat.Mode().Perm() != 0755 {
+		t.Errorf("extractArchive() mode = %v, want %v", stat.Mode().Perm(), os.FileMode(0755))
+	}
+
+	for _, name := range []string{"node_modules", "app.js.map"} {
+		if _, err := os.Stat(filepath.Join(dir, "local", "build", name)); !os.IsNotExist(err) {
+			t.Errorf("'%s' should be skipped", name)
+		}
+	}
+}
+
+func TestExtractArchiveOutsideDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s4_test_")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+
+	gzipWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	_ = tarWriter.WriteHeader(&tar.Header{Name: "../evil", Mode: 0644, Size: 4, Typeflag: tar.TypeReg})
+	_, _ = tarWriter.Write([]byte("evil"))
+	_ = tarWriter.Close()
+	_ = gzipWriter.Close()
+
+	if err := (&Client{}).extractArchive(&buf, filepath.Join(dir, "local"), TransferOptions{}, newProgressBar("test", 4), nil); err == nil {
+		t.Errorf("extractArchive() expect error")
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "evil")); !os.IsNotExist(err) {
+		t.Errorf("extractArchive() should not write the file outside of the dir")
+	}
+}
+
+func TestUploadArchiveWithoutTar(t *testing.T) {
+	c, dir, cleanup := newTestClient(t)
+	defer cleanup()
+
+	writeTestFiles(t, filepath.Join(dir, "build"), map[string]string{"index.html": "index"})
+
+	// there is no shell in test, so it falls back to SFTP
+	if err := c.Upload(filepath.Join(dir, "build"), filepath.Join(dir, "remote"), TransferOptions{Archive: true}); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "remote", "build", "index.html")); err != nil || string(b) != "index" {
+		t.Errorf("Upload() content = %q, %v", b, err)
+	}
+}
+
+func TestUploadArchive(t *testing.T) {
+	c, _, cleanup := newTestConnection(t)
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "s4_test_")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	writeTestFiles(t, filepath.Join(dir, "build"), map[string]string{
+		"index.html": "index",
+		"js/app.js":  "app",
+	})
+
+	// the existing dir is merged
+	writeTestFiles(t, filepath.Join(dir, "remote", "build"), map[string]string{
+		"index.html": "old",
+		"old.html":   "old",
+	})
+
+	remoteDir := filepath.ToSlash(filepath.Join(dir, "remote"))
+
+	if err := c.Upload(filepath.Join(dir, "build"), remoteDir, TransferOptions{Archive: true, Verify: true}); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+
+	for name, want := range map[string]string{"index.html": "index", "js/app.js": "app", "old.html": "old"} {
+		if b, err := ioutil.ReadFile(filepath.Join(dir, "remote", "build", filepath.FromSlash(name))); err != nil || string(b) != want {
+			t.Errorf("Upload() content of %s = %q, %v, want %q", name, b, err, want)
+		}
+	}
+
+	if files, err := ioutil.ReadDir(filepath.Join(dir, "remote")); err != nil || len(files) != 1 {
+		t.Errorf("the temp dir should be removed, but the remote dir has %d files, %v", len(files), err)
+	}
+}
+
+func TestUploadArchiveInterrupted(t *testing.T) {
+	c, _, cleanup := newTestConnection(t)
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "s4_test_")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	writeTestFiles(t, filepath.Join(dir, "build"), map[string]string{"index.html": "index"})
+	writeTestFiles(t, filepath.Join(dir, "remote", "build"), map[string]string{"index.html": "old"})
+
+	c.Interrupt()
+
+	if err := c.uploadArchive(filepath.Join(dir, "build"), filepath.ToSlash(filepath.Join(dir, "remote")), TransferOptions{}); err != ErrInterrupted {
+		t.Fatalf("uploadArchive() error = %v, want %v", err, ErrInterrupted)
+	}
+
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "remote", "build", "index.html")); err != nil || string(b) != "old" {
+		t.Errorf("the existing file should not be changed, content = %q, %v", b, err)
+	}
+
+	if files, err := ioutil.ReadDir(filepath.Join(dir, "remote")); err != nil || len(files) != 1 {
+		t.Errorf("the temp dir should be removed, but the remote dir has %d files, %v", len(files), err)
+	}
+}
@@ -0,0 +1,69 @@
+package ssh
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Compute the SHA-256 of file at remote server
+func (c *Client) Checksum(remoteFilePath string) (string, error) {
+	sums, err := c.remoteChecksums([]string{remoteFilePath})
+
+	if err != nil {
+		return "", err
+	}
+
+	return sums[remoteFilePath], nil
+}
+
+// Verify the SHA-256 of file at remote server. expected is in hex
+func (c *Client) VerifyChecksum(remoteFilePath string, expected string) error {
+	return c.verifyChecksums(map[string]string{remoteFilePath: expected})
+}
+
+// compare the SHA-256 of remote files with the expected ones. the key of sums is the remote file
+func (c *Client) verifyChecksums(sums map[string]string) error {
+	files := make([]string, 0, len(sums))
+
+	for file := range sums {
+		files = append(files, file)
+	}
+
+	sort.Strings(files)
+
+	actual, err := c.remoteChecksums(files)
+
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if !strings.EqualFold(actual[file], sums[file]) {
+			return fmt.Errorf("checksum mismatch of '%s', expect %s but got %s", file, strings.ToLower(sums[file]), actual[file])
+		}
+	}
+
+	return nil
+}
+
+// write the first size bytes of local file to the hash. it is for the part of file which is resumed
+func hashFilePrefix(hash io.Writer, filePath string, size int64) error {
+	if size == 0 {
+		return nil
+	}
+
+	file, err := os.Open(filePath)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	_, err = io.CopyN(hash, file, size)
+
+	return err
+}
@@ -0,0 +1,109 @@
+package ssh
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the SHA-256 of "0123456789"
+const testChecksum = "84d89877f0d4041efb6bf91a16f0248f2fd573e6af05c19f96bedb9f882f7882"
+
+func TestVerifyChecksum(t *testing.T) {
+	c, dir, cleanup := newTestClient(t)
+	defer cleanup()
+
+	writeTestFiles(t, dir, map[string]string{"app.tar.gz": "0123456789"})
+
+	file := filepath.Join(dir, "app.tar.gz")
+
+	if sum, err := c.Checksum(file); err != nil || sum != testChecksum {
+		t.Errorf("Checksum() = %s, %v", sum, err)
+	}
+
+	if err := c.VerifyChecksum(file, strings.ToUpper(testChecksum)); err != nil {
+		t.Errorf("VerifyChecksum() error = %v", err)
+	}
+
+	if err := c.VerifyChecksum(file, strings.Repeat("0", 64)); err == nil {
+		t.Errorf("VerifyChecksum() expect error")
+	}
+
+	if err := c.VerifyChecksum(filepath.Join(dir, "not_exist"), testChecksum); err == nil {
+		t.Errorf("VerifyChecksum() expect error")
+	}
+}
+
+func TestUploadVerify(t *testing.T) {
+	c, dir, cleanup := newTestClient(t)
+	defer cleanup()
+
+	localFile := filepath.Join(dir, "model.bin")
+	remoteFile := filepath.Join(dir, "remote", "model.bin")
+	tempFile := filepath.Join(dir, "remote", ".model.bin.s4tmp")
+
+	writeTestFiles(t, dir, map[string]string{"model.bin": "0123456789"})
+
+	old := time.Now().Add(-time.Hour)
+
+	if err := os.Chtimes(localFile, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	// the part of last upload is broken, so the resumed file does not match
+	writeTestFiles(t, filepath.Join(dir, "remote"), map[string]string{".model.bin.s4tmp": "abcd"})
+
+	if err := c.Upload(localFile, filepath.Join(dir, "remote"), TransferOptions{Verify: true}); err == nil {
+		t.Errorf("Upload() expect error")
+	}
+
+	if _, err := os.Stat(remoteFile); !os.IsNotExist(err) {
+		t.Errorf("Upload() should not rename the broken file")
+	}
+
+	if _, err := os.Stat(tempFile); !os.IsNotExist(err) {
+		t.Errorf("Upload() should remove the broken temp file")
+	}
+
+	if err := c.Upload(localFile, filepath.Join(dir, "remote"), TransferOptions{Verify: true}); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+
+	if b, err := ioutil.ReadFile(remoteFile); err != nil || string(b) != "0123456789" {
+		t.Errorf("Upload() content = %q, %v", b, err)
+	}
+}
+
+func TestDownloadVerify(t *testing.T) {
+	c, dir, cleanup := newTestClient(t)
+	defer cleanup()
+
+	localFile := filepath.Join(dir, "local", "model.bin")
+
+	writeTestFiles(t, dir, map[string]string{"remote/model.bin": "0123456789", "local/.model.bin.s4tmp": "abcd"})
+
+	old := time.Now().Add(-time.Hour)
+
+	if err := os.Chtimes(filepath.Join(dir, "remote", "model.bin"), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Download(filepath.Join(dir, "remote", "model.bin"), filepath.Join(dir, "local"), TransferOptions{Verify: true}); err == nil {
+		t.Errorf("Download() expect error")
+	}
+
+	if _, err := os.Stat(localFile); !os.IsNotExist(err) {
+		t.Errorf("Download() should not rename the broken file")
+	}
+
+	if err := c.Download(filepath.Join(dir, "remote", "model.bin"), filepath.Join(dir, "local"), TransferOptions{Verify: true}); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+
+	if b, err := ioutil.ReadFile(localFile); err != nil || string(b) != "0123456789" {
+		t.Errorf("Download() content = %q, %v", b, err)
+	}
+}
@@ -0,0 +1,159 @@
+package ssh
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	envKeyReg    = regexp.MustCompile("^[A-Za-z_][A-Za-z0-9_]*$")
+	safeShellReg = regexp.MustCompile(`^[\w@%+=:,./-]+$`)
+)
+
+// the shell to wrap the command with sudo if no shell specified
+var defaultShell = []string{"sh", "-c"}
+
+// whether the key is a valid name of environment variable for shell
+func isValidEnvKey(key string) bool {
+	return envKeyReg.MatchString(key)
+}
+
+// quote the string with single quote so that shell will not interpret it.
+// eg. it's -> 'it'"'"'s'
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+// join the arguments as a command line. the arguments are quoted if necessary
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+
+	for index, arg := range args {
+		if safeShellReg.MatchString(arg) {
+			quoted[index] = arg
+		} else {
+			quoted[index] = shellQuote(arg)
+		}
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// export KEY='VALUE'; command
+func setEnvForCommand(command string, env map[string]string) (string, error) {
+	var keys []string
+
+	for key := range env {
+		if !isValidEnvKey(key) {
+			return "", fmt.Errorf("invalid environment variable name `%s`", key)
+		}
+		keys = append(keys, key)
+	}
+
+	// keep the order stable
+	sort.Strings(keys)
+
+	var setEnvCommand []string
+
+	for _, key := range keys {
+		setEnvCommand = append(setEnvCommand, fmt.Sprintf("export %s=%s;", key, shellQuote(env[key])))
+	}
+
+	if len(setEnvCommand) == 0 {
+		return command, nil
+	}
+
+	return strings.Join(setEnvCommand, " ") + " " + command, nil
+}
+
+// build the command with the working directory, environment variables, shell and sudo
+func buildCommand(command string, options Options) (string, error) {
+	if options.CWD != "" {
+		command = "cd " + shellQuote(options.CWD) + " && " + command
+	}
+
+	command, err := setEnvForCommand(command, options.Env)
+
+	if err != nil {
+		return "", err
+	}
+
+	if options.Sudo != nil {
+		command = sudoCommand(command, *options.Sudo, options.Shell)
+	} else if len(options.Shell) != 0 {
+		command = shellJoin(options.Shell) + " " + shellQuote(command)
+	}
+
+	return command, nil
+}
+
+// wrap the command with sudo. eg. sudo -u www sh -c 'whoami'
+func sudoCommand(command string, sudo Sudo, shell []string) string {
+	args := []string{"sudo"}
+
+	if sudo.Password != "" {
+		// -k: ignore the cached credential so that sudo always read the password from stdin.
+		// -S: read the password from stdin. -p: hide the prompt
+		args = append(args, "-k", "-S", "-p", "''")
+	}
+
+	if sudo.User != "" {
+		args = append(args, "-u", shellQuote(sudo.User))
+	}
+
+	if len(shell) == 0 {
+		shell = defaultShell
+	}
+
+	args = append(args, shellJoin(shell), shellQuote(command))
+
+	return strings.Join(args, " ")
+}
+
+// Create a session and set the environment variables with `Setenv` if the server accepts.
+// The variables which are not accepted are returned in options, and should be exported in the command
+func (c *Client) newSession(options Options) (*ssh.Session, Options, error) {
+	session, err := c.sshClient.NewSession()
+
+	if err != nil {
+		return nil, options, err
+	}
+
+	c.trackSession(session)
+
+	// sudo resets the environment variables and the login shell may override them. export them in the command instead
+	if options.Sudo != nil || len(options.Shell) != 0 || len(options.Env) == 0 {
+		return session, options, nil
+	}
+
+	// the sessions may be created concurrently. eg. pipe
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.noSetenv == nil {
+		c.noSetenv = map[string]bool{}
+	}
+
+	rest := map[string]string{}
+
+	for key, value := range options.Env {
+		if !isValidEnvKey(key) || c.noSetenv[key] {
+			rest[key] = value
+			continue
+		}
+
+		// most servers only accept `LANG` and `LC_*` by default. remember the rejected one
+		if err := session.Setenv(key, value); err != nil {
+			c.noSetenv[key] = true
+			rest[key] = value
+		}
+	}
+
+	options.Env = rest
+
+	return session, options, nil
+}
@@ -0,0 +1,235 @@
+package ssh
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+var adversarialValues = []string{
+	"",
+	"hello world",
+	"a; rm -rf ~",
+	"$(whoami)",
+	"`whoami`",
+	"${HOME}",
+	"$HOME",
+	"it's",
+	`"double" quote`,
+	`'single' quote`,
+	`'"'"'`,
+	`back\slash\`,
+	"a && b || c | d > e < f",
+	"line1\nline2",
+	"tab\tseparated",
+	"*?[glob]",
+	"~",
+	"#comment",
+	"!history",
+	"-n",
+	"unicode 中文 ✓",
+}
+
+func requireShell(t *testing.T) string {
+	sh, err := exec.LookPath("sh")
+
+	if err != nil {
+		t.Skip("sh not found")
+	}
+
+	return sh
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		name string
+		args string
+		want string
+	}{
+		{name: "empty", args: "", want: "''"},
+		{name: "basic", args: "abc", want: "'abc'"},
+		{name: "space", args: "a b", want: "'a b'"},
+		{name: "single quote", args: "it's", want: `'it'"'"'s'`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shellQuote(tt.args); got != tt.want {
+				t.Errorf("shellQuote() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildCommandWithAdversarialEnv(t *testing.T) {
+	sh := requireShell(t)
+
+	for _, value := range adversarialValues {
+		t.Run(value, func(t *testing.T) {
+			command, err := buildCommand(`printf '%s' "$VALUE"`, Options{Env: map[string]string{"VALUE": value}})
+
+			if err != nil {
+				t.Errorf("buildCommand() error = %v", err)
+				return
+			}
+
+			output, err := exec.Command(sh, "-c", command).Output()
+
+			if err != nil {
+				t.Errorf("run `%s` error = %v", command, err)
+				return
+			}
+
+			if string(output) != value {
+				t.Errorf("buildCommand() = %v, want %v", string(output), value)
+			}
+		})
+	}
+}
+
+func TestBuildCommandWithAdversarialCWD(t *testing.T) {
+	sh := requireShell(t)
+
+	tempDir, err := ioutil.TempDir("", "s4_test_")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(tempDir)
+
+	// the directory name can not contain `/` and empty string
+	for _, value := range adversarialValues[1:] {
+		t.Run(value, func(t *testing.T) {
+			dir := filepath.Join(tempDir, value)
+
+			if err := os.Mkdir(dir, 0755); err != nil {
+				t.Skipf("can not create dir: %v", err)
+			}
+
+			command, err := buildCommand(`pwd`, Options{CWD: dir})
+
+			if err != nil {
+				t.Errorf("buildCommand() error = %v", err)
+				return
+			}
+
+			output, err := exec.Command(sh, "-c", command).Output()
+
+			if err != nil {
+				t.Errorf("run `%s` error = %v", command, err)
+				return
+			}
+
+			if got, _ := filepath.EvalSymlinks(string(output[:len(output)-1])); got != mustEvalSymlinks(t, dir) {
+				t.Errorf("buildCommand() = %v, want %v", got, dir)
+			}
+		})
+	}
+}
+
+func mustEvalSymlinks(t *testing.T, p string) string {
+	result, err := filepath.EvalSymlinks(p)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return result
+}
+
+func TestBuildCommandWithSudo(t *testing.T) {
+	command, err := buildCommand("whoami", Options{
+		CWD:  "/srv/app's",
+		Env:  map[string]string{"A": "a; b"},
+		Sudo: &Sudo{User: "www", Password: "secret"},
+	})
+
+	if err != nil {
+		t.Errorf("buildCommand() error = %v", err)
+		return
+	}
+
+	want := `sudo -k -S -p '' -u 'www' sh -c 'export A='"'"'a; b'"'"'; cd '"'"'/srv/app'"'"'"'"'"'"'"'"'s'"'"' && whoami'`
+
+	if command != want {
+		t.Errorf("buildCommand() = %v, want %v", command, want)
+	}
+}
+
+func TestBuildCommandWithInvalidEnvKey(t *testing.T) {
+	for _, key := range []string{"", "1A", "A B", "A;rm -rf ~;B", "$(whoami)", "A=B"} {
+		t.Run(key, func(t *testing.T) {
+			if _, err := buildCommand("env", Options{Env: map[string]string{key: "value"}}); err == nil {
+				t.Errorf("buildCommand() expect error for key `%s`", key)
+			}
+		})
+	}
+}
+
+func TestBuildCommandWithShell(t *testing.T) {
+	sh := requireShell(t)
+
+	for _, value := range adversarialValues {
+		t.Run(value, func(t *testing.T) {
+			command, err := buildCommand(`printf '%s' "$VALUE"`, Options{
+				Env:   map[string]string{"VALUE": value},
+				Shell: []string{sh, "-c"},
+			})
+
+			if err != nil {
+				t.Errorf("buildCommand() error = %v", err)
+				return
+			}
+
+			output, err := exec.Command(sh, "-c", command).Output()
+
+			if err != nil {
+				t.Errorf("run `%s` error = %v", command, err)
+				return
+			}
+
+			if string(output) != value {
+				t.Errorf("buildCommand() = %v, want %v", string(output), value)
+			}
+		})
+	}
+}
+
+func TestBuildCommandWithShellAndSudo(t *testing.T) {
+	command, err := buildCommand("node -v", Options{
+		Sudo:  &Sudo{User: "www"},
+		Shell: []string{"bash", "-lc"},
+	})
+
+	if err != nil {
+		t.Errorf("buildCommand() error = %v", err)
+		return
+	}
+
+	want := `sudo -u 'www' bash -lc 'node -v'`
+
+	if command != want {
+		t.Errorf("buildCommand() = %v, want %v", command, want)
+	}
+}
+
+func TestShellJoin(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{name: "basic", args: []string{"bash", "-lc"}, want: "bash -lc"},
+		{name: "path", args: []string{"/usr/bin/zsh", "-ic"}, want: "/usr/bin/zsh -ic"},
+		{name: "special", args: []string{"sh", "-c", "a b", "$(x)"}, want: `sh -c 'a b' '$(x)'`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shellJoin(tt.args); got != tt.want {
+				t.Errorf("shellJoin() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
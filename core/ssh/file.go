@@ -0,0 +1,413 @@
+package ssh
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/axetroy/s4/core/glob"
+	"github.com/pkg/sftp"
+)
+
+// the status code of sftp when the operation is not supported
+const sshFxOPUnsupported = 8
+
+// Create the dir at remote server. create the parent dirs if parents is true
+func (c *Client) Mkdir(dir string, parents bool) error {
+	if parents {
+		return c.sftpClient.MkdirAll(dir)
+	}
+
+	return c.sftpClient.Mkdir(dir)
+}
+
+// walk the files under root. symbolic links are not followed
+func (c *Client) walk(root string, recursive bool, fn func(filepath string) error) error {
+	if !recursive {
+		return fn(root)
+	}
+
+	walker := c.sftpClient.Walk(root)
+
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return err
+		}
+
+		// the mode of symbolic link can not be changed by sftp, it changes the target instead
+		if walker.Stat().Mode()&os.ModeSymlink != 0 {
+			continue
+		}
+
+		if err := fn(walker.Path()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Change the mode of file at remote server
+func (c *Client) Chmod(filepath string, mode os.FileMode, recursive bool) error {
+	return c.walk(filepath, recursive, func(filepath string) error {
+		return c.sftpClient.Chmod(filepath, mode)
+	})
+}
+
+// Change the owner of file at remote server. owner and group can be name or id, empty means not change
+func (c *Client) Chown(filepath string, owner string, group string, recursive bool) error {
+	uid, err := c.lookupID("/etc/passwd", owner)
+
+	if err != nil {
+		return fmt.Errorf("invalid user `%s`: %w", owner, err)
+	}
+
+	gid, err := c.lookupID("/etc/group", group)
+
+	if err != nil {
+		return fmt.Errorf("invalid group `%s`: %w", group, err)
+	}
+
+	return c.walk(filepath, recursive, func(filepath string) error {
+		fileUID, fileGID := uid, gid
+
+		// sftp can not change the owner only. keep the current one
+		if fileUID < 0 || fileGID < 0 {
+			stat, err := c.sftpClient.Stat(filepath)
+
+			if err != nil {
+				return err
+			}
+
+			fileStat, ok := stat.Sys().(*sftp.FileStat)
+
+			if !ok {
+				return fmt.Errorf("can not get the owner of `%s`", filepath)
+			}
+
+			if fileUID < 0 {
+				fileUID = int(fileStat.UID)
+			}
+
+			if fileGID < 0 {
+				fileGID = int(fileStat.GID)
+			}
+		}
+
+		return c.sftpClient.Chown(filepath, fileUID, fileGID)
+	})
+}
+
+// lookup the id of user or group in /etc/passwd or /etc/group. -1 means empty name
+func (c *Client) lookupID(file string, name string) (int, error) {
+	if name == "" {
+		return -1, nil
+	}
+
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+
+	f, err := c.sftpClient.Open(file)
+
+	if err != nil {
+		return -1, err
+	}
+
+	defer f.Close()
+
+	return parseIDFile(f, name)
+}
+
+// parse the file in the format of /etc/passwd or /etc/group. eg. `root:x:0:0:root:/root:/bin/bash`
+func parseIDFile(reader io.Reader, name string) (int, error) {
+	scanner := bufio.NewScanner(reader)
+
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+
+		if len(fields) < 3 || fields[0] != name {
+			continue
+		}
+
+		return strconv.Atoi(fields[2])
+	}
+
+	if err := scanner.Err(); err != nil {
+		return -1, err
+	}
+
+	return -1, fmt.Errorf("`%s` not found", name)
+}
+
+// Create the symbolic link at remote server. the existing link will be replaced atomically
+func (c *Client) Link(target string, linkname string) error {
+	tempName := path.Join(path.Dir(linkname), fmt.Sprintf(".%s.s4tmp%d", path.Base(linkname), time.Now().UnixNano()))
+
+	if err := c.sftpClient.Symlink(target, tempName); err != nil {
+		return err
+	}
+
+	if err := c.posixRename(tempName, linkname); err != nil {
+		_ = c.sftpClient.Remove(tempName)
+		return err
+	}
+
+	return nil
+}
+
+// rename the file and replace the existing one atomically.
+// if the server does not support `posix-rename@openssh.com`, remove the existing one then rename
+func (c *Client) posixRename(oldname string, newname string) error {
+	err := c.sftpClient.PosixRename(oldname, newname)
+
+	if err == nil || !isUnsupported(err) {
+		return err
+	}
+
+	if err := c.sftpClient.Remove(newname); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return c.sftpClient.Rename(oldname, newname)
+}
+
+// whether the error means the server does not support the operation
+func isUnsupported(err error) bool {
+	statusErr, ok := err.(*sftp.StatusError)
+
+	return ok && statusErr.Code == sshFxOPUnsupported
+}
+
+// Update the access and modification time of file at remote server. create an empty file if not exist
+func (c *Client) Touch(filepath string) error {
+	if _, err := c.sftpClient.Stat(filepath); os.IsNotExist(err) {
+		f, err := c.sftpClient.OpenFile(filepath, os.O_WRONLY|os.O_CREATE)
+
+		if err != nil {
+			return err
+		}
+
+		return f.Close()
+	} else if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	return c.sftpClient.Chtimes(filepath, now, now)
+}
+
+// Read the content of file at remote server
+func (c *Client) ReadFile(filepath string) ([]byte, error) {
+	f, err := c.sftpClient.Open(filepath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	return ioutil.ReadAll(f)
+}
+
+// Write the content to the file at remote server directly. zero mode means not change
+func (c *Client) WriteFile(filepath string, content []byte, mode os.FileMode) error {
+	f, err := c.sftpClient.OpenFile(filepath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(content); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if mode != 0 {
+		return c.sftpClient.Chmod(filepath, mode)
+	}
+
+	return nil
+}
+
+// Append the content to the file at remote server. create the file if not exist.
+// if the file does not end with line break, a line break will be added before the content
+func (c *Client) AppendFile(filepath string, content []byte) error {
+	f, err := c.sftpClient.OpenFile(filepath, os.O_RDWR|os.O_CREATE)
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	stat, err := f.Stat()
+
+	if err != nil {
+		return err
+	}
+
+	offset := stat.Size()
+
+	if offset > 0 {
+		last := make([]byte, 1)
+
+		if _, err := f.Seek(offset-1, io.SeekStart); err != nil {
+			return err
+		}
+
+		if _, err := io.ReadFull(f, last); err != nil {
+			return err
+		}
+
+		if last[0] != '\n' {
+			content = append([]byte{'\n'}, content...)
+		}
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	if _, err := f.Write(content); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// set the owner of file to the same as stat. it is for replacing the file by a new one.
+// only root can change the owner. ignore the error if the owner is the same
+func (c *Client) copyOwner(stat os.FileInfo, filepath string) {
+	if fileStat, ok := stat.Sys().(*sftp.FileStat); ok {
+		_ = c.sftpClient.Chown(filepath, int(fileStat.UID), int(fileStat.GID))
+	}
+}
+
+// the max number of symbolic links to follow, as the same as Linux
+const maxLinks = 40
+
+// follow the symbolic links at remote server to the final path, which may not exist
+func (c *Client) resolveLink(filepath string) (string, error) {
+	resolved := filepath
+
+	for i := 0; i < maxLinks; i++ {
+		stat, err := c.sftpClient.Lstat(resolved)
+
+		if os.IsNotExist(err) {
+			return resolved, nil
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		if stat.Mode()&os.ModeSymlink == 0 {
+			return resolved, nil
+		}
+
+		target, err := c.sftpClient.ReadLink(resolved)
+
+		if err != nil {
+			return "", err
+		}
+
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(resolved), target)
+		}
+
+		resolved = target
+	}
+
+	return "", fmt.Errorf("too many levels of symbolic links: %s", filepath)
+}
+
+// Write the content to a temp file then rename it to the file at remote server, so that the file is never half-written.
+// The mode and owner of the existing file are kept. If the file is a symbolic link, the file it points to is replaced
+func (c *Client) WriteFileAtomic(filepath string, content []byte) error {
+	// renaming replaces the link itself
+	filepath, err := c.resolveLink(filepath)
+
+	if err != nil {
+		return err
+	}
+
+	tempName := path.Join(path.Dir(filepath), fmt.Sprintf(".%s.s4tmp%d", path.Base(filepath), time.Now().UnixNano()))
+
+	stat, err := c.sftpClient.Stat(filepath)
+
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var mode os.FileMode
+
+	if stat != nil {
+		mode = stat.Mode()
+	}
+
+	if err := c.WriteFile(tempName, content, mode); err != nil {
+		_ = c.sftpClient.Remove(tempName)
+		return err
+	}
+
+	if stat != nil {
+		c.copyOwner(stat, tempName)
+	}
+
+	if err := c.posixRename(tempName, filepath); err != nil {
+		_ = c.sftpClient.Remove(tempName)
+		return err
+	}
+
+	return nil
+}
+
+// Glob returns the remote files which match the pattern. `**` matches zero or more dirs.
+// the pattern is returned as it is if it has no magic characters
+func (c *Client) Glob(pattern string) ([]string, error) {
+	if !glob.HasMeta(pattern) {
+		return []string{pattern}, nil
+	}
+
+	if !strings.Contains(pattern, "**") {
+		return c.sftpClient.Glob(pattern)
+	}
+
+	root := glob.Base(pattern)
+
+	if _, err := c.sftpClient.Stat(root); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	var matches []string
+
+	walker := c.sftpClient.Walk(root)
+
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return nil, err
+		}
+
+		// the dirs are not returned, otherwise the files in it will be duplicated
+		if !walker.Stat().IsDir() && glob.Match(pattern, walker.Path()) {
+			matches = append(matches, walker.Path())
+		}
+	}
+
+	sort.Strings(matches)
+
+	return matches, nil
+}
@@ -0,0 +1,280 @@
+package ssh
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseIDFile(t *testing.T) {
+	content := `root:x:0:0:root:/root:/bin/bash
+# comment
+www-data:x:33:33:www-data:/var/www:/usr/sbin/nologin
+invalid
+deploy:x:1000:1000::/home/deploy:/bin/bash
+`
+
+	tests := []struct {
+		name    string
+		args    string
+		want    int
+		wantErr bool
+	}{
+		{name: "root", args: "root", want: 0},
+		{name: "with dash", args: "www-data", want: 33},
+		{name: "last line", args: "deploy", want: 1000},
+		{name: "not found", args: "nobody", want: -1, wantErr: true},
+		{name: "prefix", args: "dep", want: -1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseIDFile(strings.NewReader(content), tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseIDFile() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("parseIDFile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMkdir(t *testing.T) {
+	c, dir, cleanup := newTestClient(t)
+	defer cleanup()
+
+	if err := c.Mkdir(filepath.Join(dir, "a", "b"), false); err == nil {
+		t.Errorf("Mkdir() expect error without parents")
+	}
+
+	if err := c.Mkdir(filepath.Join(dir, "a", "b"), true); err != nil {
+		t.Errorf("Mkdir() error = %v", err)
+	}
+
+	if stat, err := os.Stat(filepath.Join(dir, "a", "b")); err != nil || !stat.IsDir() {
+		t.Errorf("Mkdir() dir not created: %v", err)
+	}
+}
+
+func TestChmod(t *testing.T) {
+	c, dir, cleanup := newTestClient(t)
+	defer cleanup()
+
+	file := filepath.Join(dir, "sub", "file.txt")
+
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(file, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Chmod(dir, 0700, true); err != nil {
+		t.Errorf("Chmod() error = %v", err)
+	}
+
+	for _, p := range []string{dir, filepath.Dir(file), file} {
+		if stat, err := os.Stat(p); err != nil || stat.Mode().Perm() != 0700 {
+			t.Errorf("Chmod() mode of `%s` = %v, want %v", p, stat.Mode().Perm(), os.FileMode(0700))
+		}
+	}
+}
+
+func TestLink(t *testing.T) {
+	c, dir, cleanup := newTestClient(t)
+	defer cleanup()
+
+	link := filepath.Join(dir, "current")
+
+	for _, target := range []string{"releases/v1", "releases/v2"} {
+		if err := c.Link(target, link); err != nil {
+			t.Errorf("Link() error = %v", err)
+			return
+		}
+
+		if got, err := os.Readlink(link); err != nil || got != target {
+			t.Errorf("Link() = %v, want %v", got, target)
+		}
+	}
+
+	files, err := ioutil.ReadDir(dir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 1 {
+		t.Errorf("Link() left the temp file")
+	}
+}
+
+func TestTouch(t *testing.T) {
+	c, dir, cleanup := newTestClient(t)
+	defer cleanup()
+
+	file := filepath.Join(dir, "file.txt")
+
+	if err := c.Touch(file); err != nil {
+		t.Errorf("Touch() error = %v", err)
+	}
+
+	past := time.Now().Add(-time.Hour)
+
+	if err := os.Chtimes(file, past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Touch(file); err != nil {
+		t.Errorf("Touch() error = %v", err)
+	}
+
+	if stat, err := os.Stat(file); err != nil || stat.ModTime().Before(time.Now().Add(-time.Minute)) {
+		t.Errorf("Touch() modification time not updated")
+	}
+}
+
+func TestChown(t *testing.T) {
+	if os.Getuid() < 0 {
+		t.Skip("uid is not supported")
+	}
+
+	c, dir, cleanup := newTestClient(t)
+	defer cleanup()
+
+	file := filepath.Join(dir, "file.txt")
+
+	if err := ioutil.WriteFile(file, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// change to the current user. it keeps the group
+	if err := c.Chown(file, strconv.Itoa(os.Getuid()), "", false); err != nil {
+		t.Errorf("Chown() error = %v", err)
+	}
+
+	if err := c.Chown(file, "", strconv.Itoa(os.Getgid()), false); err != nil {
+		t.Errorf("Chown() error = %v", err)
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	c, dir, cleanup := newTestClient(t)
+	defer cleanup()
+
+	file := filepath.Join(dir, "app.conf")
+
+	if err := ioutil.WriteFile(file, []byte("a long long content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.WriteFile(file, []byte("short"), 0600); err != nil {
+		t.Errorf("WriteFile() error = %v", err)
+	}
+
+	content, err := c.ReadFile(file)
+
+	if err != nil {
+		t.Errorf("ReadFile() error = %v", err)
+	}
+
+	if string(content) != "short" {
+		t.Errorf("ReadFile() = %v, want %v", string(content), "short")
+	}
+
+	if stat, err := os.Stat(file); err != nil || stat.Mode().Perm() != 0600 {
+		t.Errorf("WriteFile() mode not changed")
+	}
+}
+
+func TestAppendFile(t *testing.T) {
+	c, dir, cleanup := newTestClient(t)
+	defer cleanup()
+
+	file := filepath.Join(dir, "hosts")
+
+	for _, line := range []string{"127.0.0.1 localhost\n", "10.0.0.5 db", "10.0.0.6 cache\n"} {
+		if err := c.AppendFile(file, []byte(line)); err != nil {
+			t.Errorf("AppendFile() error = %v", err)
+		}
+	}
+
+	want := "127.0.0.1 localhost\n10.0.0.5 db\n10.0.0.6 cache\n"
+
+	if content, err := ioutil.ReadFile(file); err != nil || string(content) != want {
+		t.Errorf("AppendFile() = %v, want %v", string(content), want)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	c, dir, cleanup := newTestClient(t)
+	defer cleanup()
+
+	file := filepath.Join(dir, "sysctl.conf")
+
+	if err := ioutil.WriteFile(file, []byte("vm.swappiness=60\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.WriteFileAtomic(file, []byte("vm.swappiness=10\n")); err != nil {
+		t.Errorf("WriteFileAtomic() error = %v", err)
+	}
+
+	if content, err := ioutil.ReadFile(file); err != nil || string(content) != "vm.swappiness=10\n" {
+		t.Errorf("WriteFileAtomic() = %v, want %v", string(content), "vm.swappiness=10\n")
+	}
+
+	if stat, err := os.Stat(file); err != nil || stat.Mode().Perm() != 0640 {
+		t.Errorf("WriteFileAtomic() mode is not kept")
+	}
+
+	if files, err := ioutil.ReadDir(dir); err != nil || len(files) != 1 {
+		t.Errorf("WriteFileAtomic() left the temp file")
+	}
+}
+
+func TestWriteFileAtomicLink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic link requires privilege on windows")
+	}
+
+	c, dir, cleanup := newTestClient(t)
+	defer cleanup()
+
+	target := filepath.Join(dir, "conf", "nginx.conf")
+
+	writeTestFiles(t, dir, map[string]string{"conf/nginx.conf": "old"})
+
+	// the link points to another link
+	if err := os.Symlink(filepath.Join("conf", "nginx.conf"), filepath.Join(dir, "current.conf")); err != nil {
+		t.Fatal(err)
+	}
+
+	link := filepath.Join(dir, "nginx.conf")
+
+	if err := os.Symlink("current.conf", link); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.WriteFileAtomic(link, []byte("new")); err != nil {
+		t.Fatalf("WriteFileAtomic() error = %v", err)
+	}
+
+	if stat, err := os.Lstat(link); err != nil || stat.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("WriteFileAtomic() should keep the link")
+	}
+
+	if content, err := ioutil.ReadFile(target); err != nil || string(content) != "new" {
+		t.Errorf("WriteFileAtomic() content of target = %q, %v, want %q", content, err, "new")
+	}
+
+	if files, err := ioutil.ReadDir(filepath.Join(dir, "conf")); err != nil || len(files) != 1 {
+		t.Errorf("WriteFileAtomic() left the temp file")
+	}
+}
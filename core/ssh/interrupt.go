@@ -0,0 +1,87 @@
+package ssh
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// the time to wait for the remote process to exit after SIGINT sent, then the session will be closed
+const interruptGracePeriod = time.Second * 3
+
+// ErrInterrupted is returned by the transfer which is stopped by Interrupt()
+var ErrInterrupted = errors.New("interrupted")
+
+// remember the session so that it can be interrupted
+func (c *Client) trackSession(session *ssh.Session) {
+	c.sessionLock.Lock()
+	defer c.sessionLock.Unlock()
+
+	if c.sessions == nil {
+		c.sessions = map[*ssh.Session]struct{}{}
+	}
+
+	c.sessions[session] = struct{}{}
+}
+
+// close the session and forget it
+func (c *Client) closeSession(session *ssh.Session) {
+	c.sessionLock.Lock()
+	delete(c.sessions, session)
+	c.sessionLock.Unlock()
+
+	_ = session.Close()
+}
+
+// Whether Interrupt() have been called
+func (c *Client) Interrupted() bool {
+	return atomic.LoadInt32(&c.interrupted) == 1
+}
+
+// Interrupt the running commands and transfers.
+// SIGINT is sent to the remote processes. if they do not exit in time, the sessions will be closed.
+func (c *Client) Interrupt() {
+	atomic.StoreInt32(&c.interrupted, 1)
+
+	c.sessionLock.Lock()
+
+	sessions := make([]*ssh.Session, 0, len(c.sessions))
+
+	for session := range c.sessions {
+		sessions = append(sessions, session)
+	}
+
+	c.sessionLock.Unlock()
+
+	if len(sessions) == 0 {
+		return
+	}
+
+	for _, session := range sessions {
+		// not all servers support signal. the session will be closed anyway
+		_ = session.Signal(ssh.SIGINT)
+	}
+
+	time.AfterFunc(interruptGracePeriod, func() {
+		for _, session := range sessions {
+			c.closeSession(session)
+		}
+	})
+}
+
+// interruptReader stop reading once the client is interrupted
+type interruptReader struct {
+	client *Client
+	reader io.Reader
+}
+
+func (r interruptReader) Read(p []byte) (int, error) {
+	if r.client.Interrupted() {
+		return 0, ErrInterrupted
+	}
+
+	return r.reader.Read(p)
+}
@@ -0,0 +1,134 @@
+package ssh
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/cheggaaa/pb/v3"
+	"github.com/fatih/color"
+)
+
+// the layout of timestamp in the name of backup file
+const backupTimeLayout = "20060102150405"
+
+// the path of backup file. eg. `app.conf.20200102150405.bak`
+func backupPath(filePath string, now time.Time) string {
+	return fmt.Sprintf("%s.%s.bak", filePath, now.Format(backupTimeLayout))
+}
+
+// whether to skip transferring the file because of the existing destination. destination is nil if it does not exist
+func skipTransfer(source os.FileInfo, destination os.FileInfo, options TransferOptions) bool {
+	if destination == nil {
+		return false
+	}
+
+	if options.NoClobber {
+		return true
+	}
+
+	// compare in seconds, SFTP does not support the smaller unit
+	return options.IfNewer && source.ModTime().Unix() <= destination.ModTime().Unix()
+}
+
+// print the file which is skipped and the reason. the size of file is counted as transferred
+func printSkipped(filePath string, size int64, options TransferOptions, bar *pb.ProgressBar) {
+	if bar != nil {
+		bar.Add64(size)
+	}
+
+	if options.NoClobber {
+		fmt.Println(color.YellowString("'%s' exists, skip it", filePath))
+	} else {
+		fmt.Println(color.YellowString("the source of '%s' is not newer, skip it", filePath))
+	}
+}
+
+// whether to skip uploading the file
+func (c *Client) skipUpload(localFilePath string, remoteFilePath string, options TransferOptions, bar *pb.ProgressBar) (bool, error) {
+	if !options.NoClobber && !options.IfNewer {
+		return false, nil
+	}
+
+	localStat, err := os.Stat(localFilePath)
+
+	if err != nil {
+		return false, err
+	}
+
+	remoteStat, err := c.sftpClient.Lstat(remoteFilePath)
+
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	if !skipTransfer(localStat, remoteStat, options) {
+		return false, nil
+	}
+
+	printSkipped(remoteFilePath, localStat.Size(), options, bar)
+
+	return true, nil
+}
+
+// whether to skip downloading the file
+func (c *Client) skipDownload(remoteFilePath string, localFilePath string, options TransferOptions, bar *pb.ProgressBar) (bool, error) {
+	if !options.NoClobber && !options.IfNewer {
+		return false, nil
+	}
+
+	remoteStat, err := c.sftpClient.Stat(remoteFilePath)
+
+	if err != nil {
+		return false, err
+	}
+
+	localStat, err := os.Lstat(localFilePath)
+
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	if !skipTransfer(remoteStat, localStat, options) {
+		return false, nil
+	}
+
+	printSkipped(localFilePath, remoteStat.Size(), options, bar)
+
+	return true, nil
+}
+
+// keep the existing remote file as the backup before it is replaced.
+// it is hard linked if the server supports, so the file always exists
+func (c *Client) backupRemote(remoteFilePath string) error {
+	if _, err := c.sftpClient.Lstat(remoteFilePath); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	backupFilePath := backupPath(remoteFilePath, time.Now())
+
+	if err := c.sftpClient.Link(remoteFilePath, backupFilePath); err == nil {
+		return nil
+	}
+
+	return c.sftpClient.Rename(remoteFilePath, backupFilePath)
+}
+
+// keep the existing local file as the backup before it is replaced
+func backupLocal(localFilePath string) error {
+	if _, err := os.Lstat(localFilePath); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	backupFilePath := backupPath(localFilePath, time.Now())
+
+	if err := os.Link(localFilePath, backupFilePath); err == nil {
+		return nil
+	}
+
+	return os.Rename(localFilePath, backupFilePath)
+}
@@ -0,0 +1,133 @@
+package ssh
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestBackupPath(t *testing.T) {
+	now := time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	if got := backupPath("/etc/nginx/nginx.conf", now); got != "/etc/nginx/nginx.conf.20200102150405.bak" {
+		t.Errorf("backupPath() = %s", got)
+	}
+}
+
+func TestUploadAs(t *testing.T) {
+	c, dir, cleanup := newTestClient(t)
+	defer cleanup()
+
+	writeTestFiles(t, filepath.Join(dir, "build"), map[string]string{"app-linux": "app", "static/index.html": "index"})
+
+	err := c.UploadFiles([]TransferFile{
+		{Source: filepath.Join(dir, "build", "app-linux"), Destination: filepath.Join(dir, "remote", "bin"), Name: "app", Options: TransferOptions{Mode: 0755}},
+		{Source: filepath.Join(dir, "build", "static"), Destination: filepath.Join(dir, "remote"), Name: "public"},
+	}, 1)
+
+	if err != nil {
+		t.Fatalf("UploadFiles() error = %v", err)
+	}
+
+	if stat, err := os.Stat(filepath.Join(dir, "remote", "bin", "app")); err != nil {
+		t.Error(err)
+	} else if runtime.GOOS != "windows" && stat.Mode().Perm() != 0755 {
+		t.Errorf("UploadFiles() mode = %v, want %v", stat.Mode().Perm(), os.FileMode(0755))
+	}
+
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "remote", "public", "index.html")); err != nil || string(b) != "index" {
+		t.Errorf("UploadFiles() content = %q, %v", b, err)
+	}
+}
+
+func TestUploadOverwrite(t *testing.T) {
+	tests := []struct {
+		name        string
+		options     TransferOptions
+		remoteNewer bool
+		want        string
+		wantBackup  bool
+	}{
+		{name: "overwrite", options: TransferOptions{}, want: "new"},
+		{name: "no clobber", options: TransferOptions{NoClobber: true}, want: "old"},
+		{name: "if newer with older remote file", options: TransferOptions{IfNewer: true}, want: "new"},
+		{name: "if newer with newer remote file", options: TransferOptions{IfNewer: true}, remoteNewer: true, want: "old"},
+		{name: "backup", options: TransferOptions{Backup: true}, want: "new", wantBackup: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, dir, cleanup := newTestClient(t)
+			defer cleanup()
+
+			writeTestFiles(t, dir, map[string]string{"local/app.conf": "new", "remote/app.conf": "old"})
+
+			remoteTime := time.Now().Add(-time.Hour)
+
+			if tt.remoteNewer {
+				remoteTime = time.Now().Add(time.Hour)
+			}
+
+			if err := os.Chtimes(filepath.Join(dir, "remote", "app.conf"), remoteTime, remoteTime); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := c.Upload(filepath.Join(dir, "local", "app.conf"), filepath.Join(dir, "remote"), tt.options); err != nil {
+				t.Fatalf("Upload() error = %v", err)
+			}
+
+			if b, err := ioutil.ReadFile(filepath.Join(dir, "remote", "app.conf")); err != nil || string(b) != tt.want {
+				t.Errorf("Upload() content = %q, %v, want %q", b, err, tt.want)
+			}
+
+			backups, _ := filepath.Glob(filepath.Join(dir, "remote", "app.conf.*.bak"))
+
+			if tt.wantBackup {
+				if len(backups) != 1 {
+					t.Fatalf("Upload() backups = %v", backups)
+				}
+
+				if b, err := ioutil.ReadFile(backups[0]); err != nil || string(b) != "old" {
+					t.Errorf("Upload() backup content = %q, %v", b, err)
+				}
+			} else if len(backups) != 0 {
+				t.Errorf("Upload() should not backup, but got %v", backups)
+			}
+		})
+	}
+}
+
+func TestDownloadOverwrite(t *testing.T) {
+	c, dir, cleanup := newTestClient(t)
+	defer cleanup()
+
+	writeTestFiles(t, dir, map[string]string{"remote/app.log": "new", "local/app.log": "old"})
+
+	if err := c.Download(filepath.Join(dir, "remote", "app.log"), filepath.Join(dir, "local"), TransferOptions{NoClobber: true}); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "local", "app.log")); err != nil || string(b) != "old" {
+		t.Errorf("Download() content = %q, %v", b, err)
+	}
+
+	if err := c.Download(filepath.Join(dir, "remote", "app.log"), filepath.Join(dir, "local"), TransferOptions{Backup: true, Mode: 0600}); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "local", "app.log")); err != nil || string(b) != "new" {
+		t.Errorf("Download() content = %q, %v", b, err)
+	}
+
+	if stat, err := os.Stat(filepath.Join(dir, "local", "app.log")); err != nil {
+		t.Error(err)
+	} else if runtime.GOOS != "windows" && stat.Mode().Perm() != 0600 {
+		t.Errorf("Download() mode = %v, want %v", stat.Mode().Perm(), os.FileMode(0600))
+	}
+
+	if backups, _ := filepath.Glob(filepath.Join(dir, "local", "app.log.*.bak")); len(backups) != 1 {
+		t.Errorf("Download() backups = %v", backups)
+	}
+}
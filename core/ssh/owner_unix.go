@@ -0,0 +1,24 @@
+// +build !windows
+
+package ssh
+
+import (
+	"os"
+	"syscall"
+)
+
+// the uid and gid of local file
+func localFileOwner(info os.FileInfo) (int, int, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+
+	if !ok {
+		return 0, 0, false
+	}
+
+	return int(stat.Uid), int(stat.Gid), true
+}
+
+// change the owner of local file. the symbolic link itself is changed instead of its target
+func setLocalFileOwner(filePath string, uid int, gid int) error {
+	return os.Lchown(filePath, uid, gid)
+}
@@ -0,0 +1,17 @@
+// +build windows
+
+package ssh
+
+import (
+	"os"
+)
+
+// the uid and gid of local file. windows does not have them
+func localFileOwner(info os.FileInfo) (int, int, bool) {
+	return 0, 0, false
+}
+
+// change the owner of local file. it is ignored on windows
+func setLocalFileOwner(filePath string, uid int, gid int) error {
+	return nil
+}
@@ -0,0 +1,136 @@
+package ssh
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// the attributes to keep when transferring files
+type Preserve struct {
+	Times bool // the modification time. the access time is kept when downloading
+	Owner bool // the uid and gid. only root can change the owner
+	Links bool // create the symbolic links instead of transferring the files they point to
+}
+
+// set the times and owner of the remote file to the same as the local file
+func (c *Client) preserveRemote(remoteFilePath string, localStat os.FileInfo, preserve Preserve) error {
+	if preserve.Times {
+		// the access time of local file is not portable
+		if err := c.sftpClient.Chtimes(remoteFilePath, time.Now(), localStat.ModTime()); err != nil {
+			return err
+		}
+	}
+
+	if preserve.Owner {
+		if uid, gid, ok := localFileOwner(localStat); ok {
+			if err := c.sftpClient.Chown(remoteFilePath, uid, gid); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// set the times and owner of the local file to the same as the remote file
+func preserveLocal(localFilePath string, remoteStat os.FileInfo, preserve Preserve) error {
+	fileStat, _ := remoteStat.Sys().(*sftp.FileStat)
+
+	if preserve.Times {
+		atime := remoteStat.ModTime()
+
+		if fileStat != nil {
+			atime = time.Unix(int64(fileStat.Atime), 0)
+		}
+
+		if err := os.Chtimes(localFilePath, atime, remoteStat.ModTime()); err != nil {
+			return err
+		}
+	}
+
+	if preserve.Owner && fileStat != nil {
+		if err := setLocalFileOwner(localFilePath, int(fileStat.UID), int(fileStat.GID)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// create the symbolic link at remote server which points to the same target as the local one
+func (c *Client) uploadLink(localFilePath string, remoteFilePath string) error {
+	target, err := os.Readlink(localFilePath)
+
+	if err != nil {
+		return err
+	}
+
+	if err := c.sftpClient.MkdirAll(path.Dir(remoteFilePath)); err != nil {
+		return err
+	}
+
+	return c.Link(filepath.ToSlash(target), remoteFilePath)
+}
+
+// create the local symbolic link which points to the same target as the remote one
+func (c *Client) downloadLink(remoteFilePath string, remoteStat os.FileInfo, localFilePath string, preserve Preserve) error {
+	target, err := c.sftpClient.ReadLink(remoteFilePath)
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(localFilePath), 0755); err != nil {
+		return err
+	}
+
+	if err := replaceLocalLink(filepath.FromSlash(target), localFilePath); err != nil {
+		return err
+	}
+
+	// the times of link can not be changed without following it
+	return preserveLocal(localFilePath, remoteStat, Preserve{Owner: preserve.Owner})
+}
+
+// create the local symbolic link. the existing file is replaced atomically
+func replaceLocalLink(target string, linkname string) error {
+	tempFilePath := localTempPath(linkname)
+
+	_ = os.Remove(tempFilePath)
+
+	if err := os.Symlink(target, tempFilePath); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tempFilePath, linkname); err != nil {
+		_ = os.Remove(tempFilePath)
+		return err
+	}
+
+	return nil
+}
+
+// whether there is a symbolic link among the parent dirs of name in dir.
+// the file may be written outside of the dir through it
+func hasLinkParent(dir string, name string) bool {
+	parent := dir
+
+	for _, part := range strings.Split(path.Dir(name), "/") {
+		if part == "." {
+			continue
+		}
+
+		parent = filepath.Join(parent, part)
+
+		if stat, err := os.Lstat(parent); err == nil && stat.Mode()&os.ModeSymlink != 0 {
+			return true
+		}
+	}
+
+	return false
+}
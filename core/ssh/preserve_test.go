@@ -0,0 +1,168 @@
+package ssh
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// create the release tree with a symbolic link. eg. current -> releases/v1
+func writeTestRelease(t *testing.T, dir string) time.Time {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic link requires privilege on windows")
+	}
+
+	writeTestFiles(t, dir, map[string]string{"releases/v1/index.html": "v1"})
+
+	if err := os.Symlink("releases/v1", filepath.Join(dir, "current")); err != nil {
+		t.Fatal(err)
+	}
+
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	if err := os.Chtimes(filepath.Join(dir, "releases", "v1", "index.html"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	return mtime
+}
+
+// check the file is transferred with the times and the link is kept
+func checkTestRelease(t *testing.T, dir string, mtime time.Time) {
+	if stat, err := os.Stat(filepath.Join(dir, "releases", "v1", "index.html")); err != nil {
+		t.Error(err)
+	} else if !stat.ModTime().Equal(mtime) {
+		t.Errorf("modification time = %v, want %v", stat.ModTime(), mtime)
+	}
+
+	if target, err := os.Readlink(filepath.Join(dir, "current")); err != nil || target != "releases/v1" {
+		t.Errorf("link target = %s, %v", target, err)
+	}
+}
+
+func TestUploadPreserve(t *testing.T) {
+	c, dir, cleanup := newTestClient(t)
+	defer cleanup()
+
+	mtime := writeTestRelease(t, filepath.Join(dir, "app"))
+
+	options := TransferOptions{Preserve: Preserve{Times: true, Owner: true, Links: true}}
+
+	if err := c.Upload(filepath.Join(dir, "app"), filepath.Join(dir, "remote"), options); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+
+	checkTestRelease(t, filepath.Join(dir, "remote", "app"), mtime)
+
+	// the link is uploaded again, it replaces the existing one
+	if err := c.Upload(filepath.Join(dir, "app", "current"), filepath.Join(dir, "remote", "app"), options); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+
+	checkTestRelease(t, filepath.Join(dir, "remote", "app"), mtime)
+}
+
+func TestDownloadPreserve(t *testing.T) {
+	c, dir, cleanup := newTestClient(t)
+	defer cleanup()
+
+	mtime := writeTestRelease(t, filepath.Join(dir, "app"))
+
+	options := TransferOptions{Preserve: Preserve{Times: true, Owner: true, Links: true}}
+
+	if err := c.Download(filepath.Join(dir, "app"), filepath.Join(dir, "local"), options); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+
+	checkTestRelease(t, filepath.Join(dir, "local", "app"), mtime)
+
+	// the link is followed without preserving links
+	if err := c.Download(filepath.Join(dir, "app", "current"), filepath.Join(dir, "followed"), TransferOptions{}); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+
+	if stat, err := os.Lstat(filepath.Join(dir, "followed", "current", "index.html")); err != nil || !stat.Mode().IsRegular() {
+		t.Errorf("Download() should follow the link, %v", err)
+	}
+}
+
+func TestArchivePreserve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s4_test_")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	c := &Client{}
+
+	mtime := writeTestRelease(t, filepath.Join(dir, "app"))
+
+	options := TransferOptions{Preserve: Preserve{Times: true, Links: true}}
+
+	entries, size, err := archiveEntries(filepath.Join(dir, "app"), options)
+
+	if err != nil {
+		t.Fatalf("archiveEntries() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+
+	bar := newProgressBar("test", size)
+
+	if err := c.writeArchive(&buf, entries, options, bar, nil); err != nil {
+		t.Fatalf("writeArchive() error = %v", err)
+	}
+
+	if err := c.extractArchive(&buf, filepath.Join(dir, "local"), options, bar, nil); err != nil {
+		t.Fatalf("extractArchive() error = %v", err)
+	}
+
+	checkTestRelease(t, filepath.Join(dir, "local", "app"), mtime)
+}
+
+func TestExtractArchiveThroughLink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s4_test_")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic link requires privilege on windows")
+	}
+
+	var buf bytes.Buffer
+
+	gzipWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	_ = tarWriter.WriteHeader(&tar.Header{Name: "app/etc", Linkname: filepath.Join(dir, "outside"), Mode: 0777, Typeflag: tar.TypeSymlink})
+	_ = tarWriter.WriteHeader(&tar.Header{Name: "app/etc/evil", Mode: 0644, Size: 4, Typeflag: tar.TypeReg})
+	_, _ = tarWriter.Write([]byte("evil"))
+	_ = tarWriter.Close()
+	_ = gzipWriter.Close()
+
+	if err := os.Mkdir(filepath.Join(dir, "outside"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	options := TransferOptions{Preserve: Preserve{Links: true}}
+
+	if err := (&Client{}).extractArchive(&buf, filepath.Join(dir, "local"), options, newProgressBar("test", 4), nil); err == nil {
+		t.Errorf("extractArchive() expect error")
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "outside", "evil")); !os.IsNotExist(err) {
+		t.Errorf("extractArchive() should not write the file through the link")
+	}
+}
@@ -0,0 +1,161 @@
+package ssh
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	defaultTerminalType   = "xterm-256color"
+	defaultTerminalWidth  = 80
+	defaultTerminalHeight = 24
+	// the max time to wait for the input of terminal before checking the command exit or not
+	inputPollInterval = 100 * time.Millisecond
+)
+
+// Run the command with a pseudo terminal. the local terminal will be put in raw mode
+// and the stdin/window size will be forwarded to remote until the command exit
+func (c *Client) RunInteractive(command string, options Options) error {
+	session, options, err := c.newSession(options)
+
+	if err != nil {
+		return err
+	}
+
+	defer c.closeSession(session)
+
+	stdinFd := int(os.Stdin.Fd())
+	stdoutFd := int(os.Stdout.Fd())
+
+	width, height := defaultTerminalWidth, defaultTerminalHeight
+
+	if terminal.IsTerminal(stdoutFd) {
+		if w, h, err := terminal.GetSize(stdoutFd); err == nil {
+			width, height = w, h
+		}
+	}
+
+	termType := os.Getenv("TERM")
+
+	if termType == "" {
+		termType = defaultTerminalType
+	}
+
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+
+	if err := session.RequestPty(termType, height, width, modes); err != nil {
+		return err
+	}
+
+	// the remote terminal will echo the input. so put the local terminal in raw mode
+	if terminal.IsTerminal(stdinFd) {
+		state, err := terminal.MakeRaw(stdinFd)
+
+		if err != nil {
+			return err
+		}
+
+		defer terminal.Restore(stdinFd, state)
+	}
+
+	// the input is copied by copyInput instead of session.Stdin, which keeps reading the terminal after the command exit
+	stdin, err := session.StdinPipe()
+
+	if err != nil {
+		return err
+	}
+
+	session.Stdout = os.Stdout
+	session.Stderr = os.Stderr
+
+	stop := watchWindowSize(stdoutFd, func(width int, height int) {
+		_ = session.WindowChange(height, width)
+	})
+
+	defer stop()
+
+	// sudo will ask the password in the terminal
+	if options.Sudo != nil {
+		options.Sudo = &Sudo{User: options.Sudo.User}
+	}
+
+	command, err = buildCommand(command, options)
+
+	if err != nil {
+		return err
+	}
+
+	if err := session.Start(command); err != nil {
+		return err
+	}
+
+	stopInput := copyInput(os.Stdin, stdin)
+
+	err = session.Wait()
+
+	// stop reading the terminal before it is restored, so the input is left for the next command
+	stopInput()
+
+	return err
+}
+
+// copy the input of file to w until the returned function is called. it waits for the input in a short time
+// and checks whether to stop, so the input after stopping is never consumed. w is closed if the file ends
+func copyInput(file *os.File, w io.WriteCloser) (stop func()) {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+
+	go func() {
+		defer close(finished)
+
+		buf := make([]byte, 32*1024)
+
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			ready, err := waitInput(file, inputPollInterval)
+
+			if err != nil {
+				return
+			}
+
+			if !ready {
+				continue
+			}
+
+			n, err := file.Read(buf)
+
+			if n > 0 {
+				if _, err := w.Write(buf[:n]); err != nil {
+					return
+				}
+			}
+
+			if err == io.EOF {
+				_ = w.Close()
+				return
+			}
+
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+	}
+}
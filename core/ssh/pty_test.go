@@ -0,0 +1,86 @@
+package ssh
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCopyInput(t *testing.T) {
+	inputReader, inputWriter, err := os.Pipe()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer inputReader.Close()
+	defer inputWriter.Close()
+
+	outputReader, outputWriter := io.Pipe()
+
+	stop := copyInput(inputReader, outputWriter)
+
+	if _, err := inputWriter.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 5)
+
+	if _, err := io.ReadFull(outputReader, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("copyInput() output = %q, %v", buf, err)
+	}
+
+	stop()
+
+	// the input after stopping is left for the next reader
+	if _, err := inputWriter.Write([]byte("next")); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = inputWriter.Close()
+
+	rest, err := ioutil.ReadAll(inputReader)
+
+	if err != nil || string(rest) != "next" {
+		t.Errorf("the input after stopping = %q, %v, want %q", rest, err, "next")
+	}
+}
+
+func TestCopyInputEOF(t *testing.T) {
+	inputReader, inputWriter, err := os.Pipe()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer inputReader.Close()
+
+	outputReader, outputWriter := io.Pipe()
+
+	stop := copyInput(inputReader, outputWriter)
+
+	defer stop()
+
+	go func() {
+		_, _ = inputWriter.Write([]byte("hello"))
+		_ = inputWriter.Close()
+	}()
+
+	done := make(chan []byte)
+
+	go func() {
+		b, _ := ioutil.ReadAll(outputReader)
+		done <- b
+	}()
+
+	select {
+	case b := <-done:
+		if string(b) != "hello" {
+			t.Errorf("copyInput() output = %q, want %q", b, "hello")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("the output should be closed at the end of input")
+	}
+}
@@ -0,0 +1,62 @@
+// +build !windows
+
+package ssh
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh/terminal"
+	"golang.org/x/sys/unix"
+)
+
+// watch the window size of terminal with SIGWINCH. call the returned function to stop watching
+func watchWindowSize(fd int, onChange func(width int, height int)) (stop func()) {
+	sig := make(chan os.Signal, 1)
+	done := make(chan struct{})
+
+	signal.Notify(sig, syscall.SIGWINCH)
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-sig:
+				if width, height, err := terminal.GetSize(fd); err == nil {
+					onChange(width, height)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(sig)
+		close(done)
+	}
+}
+
+// wait until the file can be read without blocking or the timeout
+func waitInput(file *os.File, timeout time.Duration) (bool, error) {
+	fd := int(file.Fd())
+
+	var fds unix.FdSet
+
+	fds.Set(fd)
+
+	tv := unix.NsecToTimeval(timeout.Nanoseconds())
+
+	n, err := unix.Select(fd+1, &fds, nil, nil, &tv)
+
+	if err == unix.EINTR {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
@@ -0,0 +1,126 @@
+// +build windows
+
+package ssh
+
+import (
+	"os"
+	"time"
+	"unsafe"
+
+	"golang.org/x/crypto/ssh/terminal"
+	"golang.org/x/sys/windows"
+)
+
+const keyEvent = 0x0001
+
+var (
+	kernel32              = windows.NewLazySystemDLL("kernel32.dll")
+	procPeekConsoleInputW = kernel32.NewProc("PeekConsoleInputW")
+	procReadConsoleInputW = kernel32.NewProc("ReadConsoleInputW")
+	procPeekNamedPipe     = kernel32.NewProc("PeekNamedPipe")
+)
+
+// INPUT_RECORD with KEY_EVENT_RECORD of console
+type inputRecord struct {
+	EventType       uint16
+	_               uint16
+	KeyDown         int32
+	RepeatCount     uint16
+	VirtualKeyCode  uint16
+	VirtualScanCode uint16
+	Char            uint16
+	ControlKeyState uint32
+}
+
+// Windows does not have SIGWINCH. poll the window size of terminal instead.
+// call the returned function to stop watching
+func watchWindowSize(fd int, onChange func(width int, height int)) (stop func()) {
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(time.Millisecond * 500)
+
+		defer ticker.Stop()
+
+		lastWidth, lastHeight, _ := terminal.GetSize(fd)
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				width, height, err := terminal.GetSize(fd)
+
+				if err != nil || (width == lastWidth && height == lastHeight) {
+					continue
+				}
+
+				lastWidth, lastHeight = width, height
+
+				onChange(width, height)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
+
+// wait until the file can be read without blocking or the timeout
+func waitInput(file *os.File, timeout time.Duration) (bool, error) {
+	handle := windows.Handle(file.Fd())
+
+	var mode uint32
+
+	// it is not console. eg. pipe or file
+	if err := windows.GetConsoleMode(handle, &mode); err != nil {
+		var available uint32
+
+		// it is a file, or the pipe is broken. the read does not block
+		if r, _, _ := procPeekNamedPipe.Call(uintptr(handle), 0, 0, 0, uintptr(unsafe.Pointer(&available)), 0); r == 0 {
+			return true, nil
+		}
+
+		if available == 0 {
+			time.Sleep(timeout)
+			return false, nil
+		}
+
+		return true, nil
+	}
+
+	event, err := windows.WaitForSingleObject(handle, uint32(timeout/time.Millisecond))
+
+	if err != nil {
+		return false, err
+	}
+
+	if event != windows.WAIT_OBJECT_0 {
+		return false, nil
+	}
+
+	// the read blocks on the events which are not characters, such as key up, mouse and focus. drop them
+	for {
+		var (
+			record inputRecord
+			n      uint32
+		)
+
+		if r, _, err := procPeekConsoleInputW.Call(uintptr(handle), uintptr(unsafe.Pointer(&record)), 1, uintptr(unsafe.Pointer(&n))); r == 0 {
+			return false, err
+		}
+
+		if n == 0 {
+			return false, nil
+		}
+
+		if record.EventType == keyEvent && record.KeyDown != 0 && record.Char != 0 {
+			return true, nil
+		}
+
+		if r, _, err := procReadConsoleInputW.Call(uintptr(handle), uintptr(unsafe.Pointer(&record)), 1, uintptr(unsafe.Pointer(&n))); r == 0 {
+			return false, err
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package ssh
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/axetroy/s4/core/ssh/sshtest"
+)
+
+func TestReconnect(t *testing.T) {
+	c, server, cleanup := newTestConnection(t)
+	defer cleanup()
+
+	dir, err := os.Getwd()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !c.Alive() {
+		t.Fatal("Alive() = false before dropping the connection")
+	}
+
+	server.Drop()
+
+	if c.Alive() {
+		t.Fatal("Alive() = true after dropping the connection")
+	}
+
+	if err := c.Reconnect(); err != nil {
+		t.Fatalf("Reconnect() error = %v", err)
+	}
+
+	if !c.Alive() {
+		t.Error("Alive() = false after reconnecting")
+	}
+
+	// the sftp client is re-created
+	if _, err := c.Stat(filepath.ToSlash(dir)); err != nil {
+		t.Errorf("Stat() error = %v after reconnecting", err)
+	}
+}
+
+func TestKeepAliveDetectDrop(t *testing.T) {
+	server, err := sshtest.NewServer()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Close()
+
+	password := "test"
+
+	c := NewSSH()
+
+	c.SetKeepAlive(10 * time.Millisecond)
+
+	if err := c.Connect(server.Host, server.Port, "test", &password, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Disconnect()
+
+	server.Drop()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for atomic.LoadInt32(&c.dead) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the dropped connection is not detected by keepalive")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReconnectRemoteTunnel(t *testing.T) {
+	c, server, cleanup := newTestConnection(t)
+	defer cleanup()
+
+	target, closeEcho := newEchoServer(t)
+	defer closeEcho()
+
+	addr := freeAddr(t)
+
+	tunnel, err := c.RemoteForward(addr, target)
+
+	if err != nil {
+		t.Fatalf("RemoteForward() error = %v", err)
+	}
+
+	defer tunnel.Close()
+
+	server.Drop()
+
+	checkNotListening(t, addr)
+
+	if err := c.Reconnect(); err != nil {
+		t.Fatalf("Reconnect() error = %v", err)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+
+	if err != nil {
+		t.Fatalf("the remote tunnel is not listened again after reconnecting: %v", err)
+	}
+
+	defer conn.Close()
+
+	checkEcho(t, conn, "hello")
+}
@@ -0,0 +1,51 @@
+package ssh
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/pkg/sftp"
+)
+
+// create a client which connect to a sftp server in memory. it works on the local file system.
+// it returns the client, a temp dir and the function to clean up
+func newTestClient(t *testing.T) (*Client, string, func()) {
+	serverReader, clientWriter := io.Pipe()
+	clientReader, serverWriter := io.Pipe()
+
+	server, err := sftp.NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{serverReader, serverWriter})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		_ = server.Serve()
+	}()
+
+	sftpClient, err := sftp.NewClientPipe(clientReader, clientWriter)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tempDir, err := ioutil.TempDir("", "s4_test_")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cleanup := func() {
+		// close the server first. the client waits for the end of server output
+		_ = server.Close()
+		_ = sftpClient.Close()
+		_ = os.RemoveAll(tempDir)
+	}
+
+	return &Client{sftpClient: sftpClient}, tempDir, cleanup
+}
@@ -3,15 +3,23 @@ package ssh
 import (
 	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/axetroy/s4/core/glob"
 	"github.com/cheggaaa/pb/v3"
 	"github.com/fatih/color"
 	"github.com/pkg/sftp"
@@ -34,15 +42,69 @@ func (w Writer) Write(p []byte) (n int, err error) {
 }
 
 type Client struct {
-	sshClient  *ssh.Client
-	sftpClient *sftp.Client
+	sshClient     *ssh.Client
+	sftpClient    *sftp.Client
+	addr          string            // the address of server
+	config        *ssh.ClientConfig // the config to dial. use for reconnecting
+	keepAlive     time.Duration     // the interval to send keepalive request, zero means disable
+	dead          int32             // whether the connection is dead which detected by keepalive
+	done          chan struct{}     // close to stop keepalive
+	noSetenv      map[string]bool   // the environment variables which the server does not accept
+	lock          sync.Mutex
+	sessions      map[*ssh.Session]struct{} // the running sessions which will be interrupted by Interrupt()
+	sessionLock   sync.Mutex
+	interrupted   int32                // whether Interrupt() have been called
+	remoteTunnels map[*Tunnel]struct{} // the open tunnels of `TUNNEL REMOTE`. they are listened again after reconnecting
 }
 
 type Options struct {
-	CWD string            `json:"cwd"`
-	Env map[string]string `json:"env"`
+	CWD   string            `json:"cwd"`
+	Env   map[string]string `json:"env"`
+	Sudo  *Sudo             `json:"sudo"`  // run the command with sudo
+	Shell []string          `json:"shell"` // wrap the command with the shell. eg. ["bash", "-lc"]. empty means the default of server
 }
 
+// the options to upload or download files
+type TransferOptions struct {
+	Ignore    *glob.Ignore // the files to skip in the dir. the path is relative to the dir
+	Archive   bool         // transfer the files as one tar.gz stream. it falls back to SFTP if `tar` does not exist at remote server
+	Verify    bool         // compare the SHA-256 of the transferred files with the source files
+	Preserve  Preserve     // the attributes to keep
+	Mode      os.FileMode  // the mode of transferred files. zero means the same as the source
+	IfNewer   bool         // skip the file if the destination is not older than the source
+	NoClobber bool         // skip the file if the destination exists
+	Backup    bool         // rename the existing destination to `name.<timestamp>.bak` before replacing it
+}
+
+// the file or dir to upload or download
+type TransferFile struct {
+	Source      string
+	Destination string // the dir to transfer to
+	Name        string // the name in the destination dir. empty means the base name of source
+	Options     TransferOptions
+}
+
+// the path to transfer the file to
+func (f TransferFile) destinationPath() string {
+	if f.Name != "" {
+		return path.Join(f.Destination, f.Name)
+	}
+
+	return path.Join(f.Destination, path.Base(filepath.ToSlash(f.Source)))
+}
+
+type Sudo struct {
+	User     string `json:"user"`     // run as the user. empty means root
+	Password string `json:"password"` // the password for sudo. empty means no password required
+}
+
+const (
+	// If the server does not reply keepalive request for these times, the connection is considered dead
+	keepAliveMaxMissed = 3
+	// The timeout to check the connection is alive or not if keepalive not enable
+	aliveCheckTimeout = time.Second * 15
+)
+
 var (
 	// Linux 的内置目录路径，删除这些路径可能会导致系统崩溃
 	// 可以删除他下面的路径，但是不能直接删除目录
@@ -84,23 +146,6 @@ func IsLinuxBuildInPath(filepath string) bool {
 	}
 }
 
-func setEnvForCommand(command string, env map[string]string) (newCommand string) {
-	var setEnvCommand []string
-
-	for key, value := range env {
-		// export KEY=VALUE
-		setEnvCommand = append(setEnvCommand, fmt.Sprintf("export %s=%s;", key, value))
-	}
-
-	if len(setEnvCommand) != 0 {
-		newCommand = strings.Join(setEnvCommand, " ") + " " + command
-	} else {
-		newCommand = command
-	}
-
-	return
-}
-
 func NewSSH() *Client {
 	return &Client{
 		sshClient:  nil,
@@ -108,6 +153,12 @@ func NewSSH() *Client {
 	}
 }
 
+// Set the interval of sending keepalive request. zero means disable.
+// It should be call before Connect()
+func (c *Client) SetKeepAlive(interval time.Duration) {
+	c.keepAlive = interval
+}
+
 func (c *Client) Connect(host, port, username string, password *string, privateKey *[]byte) error {
 	var authMethods []ssh.AuthMethod
 
@@ -136,25 +187,126 @@ func (c *Client) Connect(host, port, username string, password *string, privateK
 		Timeout:         time.Second * 30,
 	}
 
-	addr := fmt.Sprintf("%s:%v", host, port)
+	c.addr = net.JoinHostPort(host, port)
+	c.config = sshConfig
+
+	return c.dial()
+}
 
-	if sshClient, err := ssh.Dial("tcp", addr, sshConfig); err != nil {
+func (c *Client) dial() error {
+	if sshClient, err := ssh.Dial("tcp", c.addr, c.config); err != nil {
 		return err
 	} else {
 		c.sshClient = sshClient
 
 		// create sftp client
 		if sftpClient, err := sftp.NewClient(sshClient); err != nil {
+			_ = sshClient.Close()
 			return err
 		} else {
 			c.sftpClient = sftpClient
 		}
 	}
 
+	atomic.StoreInt32(&c.dead, 0)
+
+	c.done = make(chan struct{})
+
+	if c.keepAlive > 0 {
+		go c.keepAliveLoop(c.sshClient, c.done)
+	}
+
 	return nil
 }
 
+// send keepalive request to server periodically.
+// if the server does not reply, close the connection so that the running session will not hang forever.
+func (c *Client) keepAliveLoop(client *ssh.Client, done chan struct{}) {
+	ticker := time.NewTicker(c.keepAlive)
+
+	defer ticker.Stop()
+
+	missed := 0
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := sendKeepAlive(client, c.keepAlive); err != nil {
+				missed++
+			} else {
+				missed = 0
+			}
+
+			if missed >= keepAliveMaxMissed {
+				atomic.StoreInt32(&c.dead, 1)
+				_ = client.Close()
+				return
+			}
+		}
+	}
+}
+
+func sendKeepAlive(client *ssh.Client, timeout time.Duration) error {
+	reply := make(chan error, 1)
+
+	go func() {
+		// the server may reply failure for the unknown request. but it still alive
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		reply <- err
+	}()
+
+	select {
+	case err := <-reply:
+		return err
+	case <-time.After(timeout):
+		return errors.New("keepalive timeout")
+	}
+}
+
+// Check the connection is still alive or not
+func (c *Client) Alive() bool {
+	if c.sshClient == nil || atomic.LoadInt32(&c.dead) == 1 {
+		return false
+	}
+
+	timeout := c.keepAlive
+
+	if timeout <= 0 {
+		timeout = aliveCheckTimeout
+	}
+
+	if err := sendKeepAlive(c.sshClient, timeout); err != nil {
+		atomic.StoreInt32(&c.dead, 1)
+		return false
+	}
+
+	return true
+}
+
+// Reconnect to the server with the same config. the sftp client will be re-created too
+func (c *Client) Reconnect() error {
+	if c.config == nil {
+		return errors.New("can not reconnect before connect")
+	}
+
+	// the connection is dead. ignore the error
+	_ = c.Disconnect()
+
+	if err := c.dial(); err != nil {
+		return err
+	}
+
+	return c.relistenTunnels()
+}
+
 func (c *Client) Disconnect() error {
+	if c.done != nil {
+		close(c.done)
+		c.done = nil
+	}
+
 	if c.sshClient != nil {
 		if err := c.sshClient.Close(); err != nil {
 			return err
@@ -170,21 +322,27 @@ func (c *Client) Disconnect() error {
 	return nil
 }
 
+func (c *Client) Stat(remotePath string) (os.FileInfo, error) {
+	return c.sftpClient.Stat(remotePath)
+}
+
 func (c *Client) Pwd() (string, error) {
 	return c.sftpClient.Getwd()
 }
 
 func (c *Client) Env(key string, options Options) (string, error) {
-	command := fmt.Sprintf("echo $%s", key)
+	if !isValidEnvKey(key) {
+		return "", fmt.Errorf("invalid environment variable name `%s`", key)
+	}
 
 	// Create a session. It is one session per command.
-	session, err := c.sshClient.NewSession()
+	session, options, err := c.newSession(options)
 
 	if err != nil {
 		return "", err
 	}
 
-	defer session.Close()
+	defer c.closeSession(session)
 
 	var stdoutBuf bytes.Buffer
 	var stderrBuf bytes.Buffer
@@ -192,7 +350,13 @@ func (c *Client) Env(key string, options Options) (string, error) {
 	session.Stdout = &stdoutBuf
 	session.Stderr = &stderrBuf
 
-	command = setEnvForCommand(command, options.Env)
+	options.CWD = ""
+
+	command, err := buildCommand(fmt.Sprintf(`printf '%%s\n' "$%s"`, key), options)
+
+	if err != nil {
+		return "", err
+	}
 
 	if err = session.Run(command); err != nil {
 		return "", err
@@ -205,20 +369,23 @@ func (c *Client) Run(command string, options Options) (stdout bytes.Buffer, stde
 	var session *ssh.Session
 
 	// Create a session. It is one session per command.
-	if session, err = c.sshClient.NewSession(); err != nil {
+	if session, options, err = c.newSession(options); err != nil {
 		return
 	}
 
-	defer session.Close()
+	defer c.closeSession(session)
 
 	session.Stdout = Writer{output: os.Stdout, data: &stdout}
 	session.Stderr = Writer{output: os.Stderr, data: &stderr}
 
-	if options.CWD != "" {
-		command = "cd " + options.CWD + " && " + command
+	// feed the password to `sudo -S` so that it will not be echoed
+	if options.Sudo != nil && options.Sudo.Password != "" {
+		session.Stdin = strings.NewReader(options.Sudo.Password + "\n")
 	}
 
-	command = setEnvForCommand(command, options.Env)
+	if command, err = buildCommand(command, options); err != nil {
+		return
+	}
 
 	if err = session.Run(command); err != nil {
 		return
@@ -227,7 +394,184 @@ func (c *Client) Run(command string, options Options) (stdout bytes.Buffer, stde
 	return
 }
 
-func (c *Client) downloadFile(remoteFilePath string, localDir string) error {
+// Run the command with the given stdin, stdout and stderr. it does not buffer the output.
+func (c *Client) Stream(command string, options Options, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	// Create a session. It is one session per command.
+	session, options, err := c.newSession(options)
+
+	if err != nil {
+		return err
+	}
+
+	defer c.closeSession(session)
+
+	session.Stdin = stdin
+	session.Stdout = stdout
+	session.Stderr = stderr
+
+	// feed the password to `sudo -S` before the stdin
+	if options.Sudo != nil && options.Sudo.Password != "" {
+		password := strings.NewReader(options.Sudo.Password + "\n")
+
+		if stdin != nil {
+			session.Stdin = io.MultiReader(password, stdin)
+		} else {
+			session.Stdin = password
+		}
+	}
+
+	command, err = buildCommand(command, options)
+
+	if err != nil {
+		return err
+	}
+
+	return session.Run(command)
+}
+
+// Run the command without printing the output. the stdout is returned
+func (c *Client) runQuiet(command string, options Options) (string, error) {
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+
+	if err := c.Stream(command, options, nil, &stdout, &stderr); err != nil {
+		return "", commandError(err, stderr)
+	}
+
+	return stdout.String(), nil
+}
+
+// the error of remote command with its output
+func commandError(err error, stderr bytes.Buffer) error {
+	if message := strings.TrimSpace(stderr.String()); message != "" {
+		return errors.New(message)
+	}
+
+	return err
+}
+
+// Get the exit code of remote command from the error
+func ExitCode(err error) (int, bool) {
+	var exitErr *ssh.ExitError
+
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus(), true
+	}
+
+	return 0, false
+}
+
+// Check that sudo need password or not
+func (c *Client) SudoRequirePassword() (bool, error) {
+	session, err := c.sshClient.NewSession()
+
+	if err != nil {
+		return false, err
+	}
+
+	defer session.Close()
+
+	// -n: non-interactive. it fails if password is required
+	if err := session.Run("sudo -n true"); err != nil {
+		if _, ok := err.(*ssh.ExitError); ok {
+			return true, nil
+		}
+		return false, err
+	}
+
+	return false, nil
+}
+
+// Upload the files to a temp dir, then copy them to the remote dirs with sudo
+func (c *Client) SudoUpload(files []TransferFile, workers int, options Options) error {
+	// `mktemp` creates the dir with a random name and mode 0700, so other users can not read or replace the files in it
+	stdout, err := c.runQuiet("mktemp -d /tmp/.s4_upload_XXXXXXXXXX", Options{})
+
+	if err != nil {
+		return fmt.Errorf("create temp dir fail: %s", err)
+	}
+
+	tempDir := strings.TrimSpace(stdout)
+
+	if !path.IsAbs(tempDir) {
+		return fmt.Errorf("create temp dir fail: unexpected output `%s` of mktemp", tempDir)
+	}
+
+	// the temp files may be given to the sudo user before copying, so they are removed with sudo
+	defer func() {
+		_, _ = c.runQuiet("rm -rf "+shellQuote(tempDir), Options{Sudo: options.Sudo})
+	}()
+
+	// each file is uploaded to its own temp dir, because they may be copied to different dirs
+	tempFiles := make([]TransferFile, 0, len(files))
+
+	for index, file := range files {
+		tempOptions := file.Options
+
+		// the user can not change the owner of temp files to others
+		tempOptions.Preserve.Owner = false
+
+		tempFiles = append(tempFiles, TransferFile{Source: file.Source, Destination: path.Join(tempDir, strconv.Itoa(index)), Name: file.Name, Options: tempOptions})
+	}
+
+	if err := c.UploadFiles(tempFiles, workers); err != nil {
+		return err
+	}
+
+	for index, file := range files {
+		remoteDir := file.Destination
+		fileTempDir := tempFiles[index].Destination
+
+		// the symbolic links are copied as they are by `cp -R`
+		command := fmt.Sprintf("mkdir -p %s && cp -R %s %s", shellQuote(remoteDir), shellQuote(fileTempDir+"/."), shellQuote(remoteDir+"/"))
+
+		// the mode of existing file is not changed by `cp` without `-p`. `-p` keeps the owner as well,
+		// so the temp files are given to the sudo user first, as if they are created by it
+		if file.Options.Mode != 0 || file.Options.Preserve.Times {
+			command = fmt.Sprintf(`mkdir -p %s && chown -R "$(id -u):$(id -g)" %s && cp -Rp %s %s`, shellQuote(remoteDir), shellQuote(fileTempDir), shellQuote(fileTempDir+"/."), shellQuote(remoteDir+"/"))
+		}
+
+		if _, err := c.runQuiet(command, Options{Sudo: options.Sudo}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// start a progress bar of transferring the file
+func newProgressBar(name string, size int64) *pb.ProgressBar {
+	tmpl := fmt.Sprintf(`{{string . "prefix"}}{{ green "%s" }} {{counters . }} {{ bar . "[" "=" ">" "-" "]"}} {{percent . }} {{speed . }}{{string . "suffix"}}`, name)
+
+	// start bar based on our template
+	bar := pb.ProgressBarTemplate(tmpl).Start64(size)
+
+	bar.Set(pb.Bytes, true)
+	bar.SetWriter(os.Stdout)
+
+	return bar
+}
+
+// Download the file to the temp file, then rename it, so the file is never half-written.
+// If the temp file is left by the last failed download, the download resumes from the end of it.
+// The progress is shown in bar if it is not nil, otherwise in a new bar of the file
+func (c *Client) downloadFile(remoteFilePath string, localFilePath string, options TransferOptions, bar *pb.ProgressBar) error {
+	if skip, err := c.skipDownload(remoteFilePath, localFilePath, options, bar); err != nil || skip {
+		return err
+	}
+
+	if options.Preserve.Links {
+		if stat, err := c.sftpClient.Lstat(remoteFilePath); err == nil && stat.Mode()&os.ModeSymlink != 0 {
+			if options.Backup {
+				if err := backupLocal(localFilePath); err != nil {
+					return err
+				}
+			}
+
+			return c.downloadLink(remoteFilePath, stat, localFilePath, options.Preserve)
+		}
+	}
+
 	remoteFile, err := c.sftpClient.Open(remoteFilePath)
 
 	if err != nil {
@@ -242,15 +586,29 @@ func (c *Client) downloadFile(remoteFilePath string, localDir string) error {
 		return err
 	}
 
-	localFileName := path.Base(remoteFilePath)
-	localFilePath := path.Join(localDir, localFileName)
+	tempFilePath := localTempPath(localFilePath)
 
 	// ensure local dir exist
-	if err := os.MkdirAll(localDir, 0755); err != nil {
+	if err := os.MkdirAll(filepath.Dir(localFilePath), 0755); err != nil {
 		return err
 	}
 
-	localFile, err := os.Create(localFilePath)
+	remoteFileSize := remoteFileStat.Size()
+
+	var offset int64
+
+	// the temp file is written after the remote file is modified, so it is a part of the remote file
+	if tempStat, err := os.Stat(tempFilePath); err == nil && tempStat.Size() < remoteFileSize && tempStat.ModTime().Unix() >= remoteFileStat.ModTime().Unix() {
+		offset = tempStat.Size()
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE
+
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+
+	localFile, err := os.OpenFile(tempFilePath, flags, 0644)
 
 	if err != nil {
 		return err
@@ -258,74 +616,204 @@ func (c *Client) downloadFile(remoteFilePath string, localDir string) error {
 
 	defer localFile.Close()
 
-	remoteFileSize := remoteFileStat.Size()
+	if _, err := localFile.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
 
-	tmpl := fmt.Sprintf(`{{string . "prefix"}}{{ green "%s" }} {{counters . }} {{ bar . "[" "=" ">" "-" "]"}} {{percent . }} {{speed . }}{{string . "suffix"}}`, localFilePath)
+	if _, err := remoteFile.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
 
-	// start bar based on our template
-	bar := pb.ProgressBarTemplate(tmpl).Start64(remoteFileSize)
+	hash := sha256.New()
 
-	bar.Set(pb.Bytes, true)
-	bar.SetWriter(os.Stdout)
+	if options.Verify {
+		if err := hashFilePrefix(hash, tempFilePath, offset); err != nil {
+			return err
+		}
+	}
+
+	ownBar := bar == nil
+
+	if ownBar {
+		bar = newProgressBar(localFilePath, remoteFileSize)
+	}
 
-	barReader := bar.NewProxyReader(remoteFile)
+	bar.Add64(offset)
+
+	var reader io.Reader = bar.NewProxyReader(interruptReader{client: c, reader: remoteFile})
+
+	if options.Verify {
+		reader = io.TeeReader(reader, hash)
+	}
+
+	if _, err := io.Copy(localFile, reader); err != nil {
+		// keep the temp file to resume next time, unless it is interrupted by user
+		if err == ErrInterrupted {
+			_ = localFile.Close()
+			_ = os.Remove(tempFilePath)
+		}
+		return err
+	}
+
+	if err := localFile.Close(); err != nil {
+		return err
+	}
+
+	if options.Verify {
+		// the temp file is broken, do not resume from it
+		if err := c.VerifyChecksum(remoteFilePath, hex.EncodeToString(hash.Sum(nil))); err != nil {
+			_ = os.Remove(tempFilePath)
+			return err
+		}
+	}
+
+	mode := remoteFileStat.Mode()
+
+	if options.Mode != 0 {
+		mode = options.Mode
+	}
 
 	// update mode
-	if err := os.Chmod(localFilePath, remoteFileStat.Mode()); err != nil {
+	if err := os.Chmod(tempFilePath, mode); err != nil {
 		return err
 	}
 
-	if _, err := io.Copy(localFile, barReader); err != nil {
+	if err := preserveLocal(tempFilePath, remoteFileStat, options.Preserve); err != nil {
+		return err
+	}
+
+	if options.Backup {
+		if err := backupLocal(localFilePath); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(tempFilePath, localFilePath); err != nil {
 		return err
 	}
 
-	bar.Finish()
+	if ownBar {
+		bar.Finish()
+	}
 
 	return nil
 }
 
-func (c *Client) downloadDir(remoteFilePath string, localDir string) error {
+// list the files in the dir to download to the local dir. rel is the path relative to the dir to download. it is used to match the ignore rules
+func (c *Client) downloadDir(remoteFilePath string, localDir string, rel string, options TransferOptions) ([]transferTask, error) {
 	files, err := c.sftpClient.ReadDir(remoteFilePath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	localDir = path.Join(localDir, path.Base(remoteFilePath))
+	var tasks []transferTask
 
 	for _, file := range files {
 		fileName := file.Name()
 		absFilePath := path.Join(remoteFilePath, fileName)
 
+		if options.Ignore.Match(path.Join(rel, fileName), file.IsDir()) {
+			continue
+		}
+
 		if file.IsDir() {
-			if err := c.downloadDir(absFilePath, path.Join(localDir, fileName)); err != nil {
-				return nil
+			dirTasks, err := c.downloadDir(absFilePath, path.Join(localDir, fileName), path.Join(rel, fileName), options)
+
+			if err != nil {
+				return nil, err
 			}
+
+			tasks = append(tasks, dirTasks...)
 		} else {
-			if err := c.downloadFile(absFilePath, localDir); err != nil {
-				return nil
+			tasks = append(tasks, transferTask{source: absFilePath, destination: path.Join(localDir, fileName), size: file.Size(), options: options})
+		}
+	}
+
+	return tasks, nil
+}
+
+func (c *Client) Download(remoteFilePath string, localDir string, options TransferOptions) error {
+	return c.DownloadFiles([]TransferFile{{Source: remoteFilePath, Destination: localDir, Options: options}}, 1)
+}
+
+// Download the files. the files are downloaded by the workers concurrently if workers is greater than 1
+func (c *Client) DownloadFiles(files []TransferFile, workers int) error {
+	var tasks []transferTask
+
+	for _, file := range files {
+		stat := c.sftpClient.Stat
+
+		// the link itself is downloaded
+		if file.Options.Preserve.Links {
+			stat = c.sftpClient.Lstat
+		}
+
+		remoteFileStat, err := stat(file.Source)
+
+		if err != nil {
+			return err
+		}
+
+		// the archive can not be extracted with a new name
+		if file.Options.Archive && file.Name == "" {
+			if c.hasCommand("tar") {
+				if err := c.downloadArchive(file.Source, file.Destination, file.Options); err != nil {
+					return err
+				}
+
+				continue
 			}
+
+			fmt.Println(color.YellowString("`tar` is not found at remote server, download '%s' by SFTP", file.Source))
+		}
+
+		// if it is a directory
+		if remoteFileStat.IsDir() {
+			dirTasks, err := c.downloadDir(file.Source, file.destinationPath(), "", file.Options)
+
+			if err != nil {
+				return err
+			}
+
+			tasks = append(tasks, dirTasks...)
+		} else {
+			tasks = append(tasks, transferTask{source: file.Source, destination: file.destinationPath(), size: remoteFileStat.Size(), options: file.Options})
 		}
 	}
 
-	return nil
+	return runTransferTasks(tasks, workers, c.downloadFile)
 }
 
-func (c *Client) Download(remoteFilePath string, localDir string) error {
-	remoteFileStat, err := c.sftpClient.Stat(remoteFilePath)
+// the temp file to transfer to. it is renamed to the file when the transfer is completed
+func transferTempPath(remoteFilePath string) string {
+	return path.Join(path.Dir(remoteFilePath), "."+path.Base(remoteFilePath)+".s4tmp")
+}
 
-	if err != nil {
+// the local temp file to download to, as transferTempPath but with the path separator of OS
+func localTempPath(localFilePath string) string {
+	return filepath.Join(filepath.Dir(localFilePath), "."+filepath.Base(localFilePath)+".s4tmp")
+}
+
+// Upload the file to the temp file, then rename it, so the file is never half-written.
+// If the temp file is left by the last failed upload, the upload resumes from the end of it.
+// The progress is shown in bar if it is not nil, otherwise in a new bar of the file
+func (c *Client) uploadFile(localFilePath string, remoteFilePath string, options TransferOptions, bar *pb.ProgressBar) error {
+	if skip, err := c.skipUpload(localFilePath, remoteFilePath, options, bar); err != nil || skip {
 		return err
 	}
 
-	// if it is a directory
-	if remoteFileStat.IsDir() {
-		return c.downloadDir(remoteFilePath, localDir)
-	} else {
-		return c.downloadFile(remoteFilePath, localDir)
+	if options.Preserve.Links {
+		if stat, err := os.Lstat(localFilePath); err == nil && stat.Mode()&os.ModeSymlink != 0 {
+			if options.Backup {
+				if err := c.backupRemote(remoteFilePath); err != nil {
+					return err
+				}
+			}
+
+			return c.uploadLink(localFilePath, remoteFilePath)
+		}
 	}
-}
 
-func (c *Client) uploadFile(localFilePath string, remoteDir string) error {
 	localFile, err := os.Open(localFilePath)
 
 	if err != nil {
@@ -340,14 +828,28 @@ func (c *Client) uploadFile(localFilePath string, remoteDir string) error {
 		return err
 	}
 
-	remoteFileName := path.Base(localFilePath)
-	remoteFilePath := path.Join(remoteDir, remoteFileName)
+	tempFilePath := transferTempPath(remoteFilePath)
 
-	if err := c.sftpClient.MkdirAll(remoteDir); err != nil {
+	if err := c.sftpClient.MkdirAll(path.Dir(remoteFilePath)); err != nil {
 		return err
 	}
 
-	remoteFile, err := c.sftpClient.Create(remoteFilePath)
+	localFileSize := localFileStat.Size()
+
+	var offset int64
+
+	// the temp file is written after the local file is modified, so it is a part of the local file
+	if tempStat, err := c.sftpClient.Stat(tempFilePath); err == nil && tempStat.Size() < localFileSize && tempStat.ModTime().Unix() >= localFileStat.ModTime().Unix() {
+		offset = tempStat.Size()
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE
+
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+
+	remoteFile, err := c.sftpClient.OpenFile(tempFilePath, flags)
 
 	if err != nil {
 		return err
@@ -355,72 +857,178 @@ func (c *Client) uploadFile(localFilePath string, remoteDir string) error {
 
 	defer remoteFile.Close()
 
-	// update file mode
-	if err := c.sftpClient.Chmod(remoteFilePath, localFileStat.Mode()); err != nil {
+	if _, err := remoteFile.Seek(offset, io.SeekStart); err != nil {
 		return err
 	}
 
-	localFileSize := localFileStat.Size()
+	if _, err := localFile.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
 
-	tmpl := fmt.Sprintf(`{{string . "prefix"}}{{ green "%s" }} {{counters . }} {{ bar . "[" "=" ">" "-" "]"}} {{percent . }} {{speed . }}{{string . "suffix"}}`, remoteFilePath)
+	hash := sha256.New()
 
-	// start bar based on our template
-	bar := pb.ProgressBarTemplate(tmpl).Start64(localFileSize)
+	if options.Verify {
+		if err := hashFilePrefix(hash, localFilePath, offset); err != nil {
+			return err
+		}
+	}
 
-	bar.Set(pb.Bytes, true)
-	bar.SetWriter(os.Stdout)
+	ownBar := bar == nil
+
+	if ownBar {
+		bar = newProgressBar(remoteFilePath, localFileSize)
+	}
+
+	bar.Add64(offset)
 
 	localFileReader := bufio.NewReader(localFile)
 
-	barReader := bar.NewProxyReader(localFileReader)
+	var reader io.Reader = bar.NewProxyReader(interruptReader{client: c, reader: localFileReader})
+
+	if options.Verify {
+		reader = io.TeeReader(reader, hash)
+	}
+
+	if _, err := remoteFile.ReadFrom(reader); err != nil {
+		// keep the temp file to resume next time, unless it is interrupted by user
+		if err == ErrInterrupted {
+			_ = remoteFile.Close()
+			_ = c.sftpClient.Remove(tempFilePath)
+		}
+		return err
+	}
+
+	if err := remoteFile.Close(); err != nil {
+		return err
+	}
+
+	if options.Verify {
+		// the temp file is broken, do not resume from it
+		if err := c.VerifyChecksum(tempFilePath, hex.EncodeToString(hash.Sum(nil))); err != nil {
+			_ = c.sftpClient.Remove(tempFilePath)
+			return err
+		}
+	}
+
+	mode := localFileStat.Mode()
+
+	if options.Mode != 0 {
+		mode = options.Mode
+	}
+
+	// update file mode
+	if err := c.sftpClient.Chmod(tempFilePath, mode); err != nil {
+		return err
+	}
+
+	if stat, err := c.sftpClient.Stat(remoteFilePath); err == nil {
+		c.copyOwner(stat, tempFilePath)
+	}
+
+	if err := c.preserveRemote(tempFilePath, localFileStat, options.Preserve); err != nil {
+		return err
+	}
+
+	if options.Backup {
+		if err := c.backupRemote(remoteFilePath); err != nil {
+			return err
+		}
+	}
 
-	if _, err := remoteFile.ReadFrom(barReader); err != nil {
+	if err := c.posixRename(tempFilePath, remoteFilePath); err != nil {
 		return err
 	}
 
-	bar.Finish()
+	if ownBar {
+		bar.Finish()
+	}
 
 	return nil
 }
 
-func (c *Client) uploadDir(localFilePath string, remoteDir string) error {
+// list the files in the dir to upload to the remote dir. rel is the path relative to the dir to upload. it is used to match the ignore rules
+func (c *Client) uploadDir(localFilePath string, remoteDir string, rel string, options TransferOptions) ([]transferTask, error) {
 	files, err := ioutil.ReadDir(localFilePath)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	remoteDir = path.Join(remoteDir, path.Base(localFilePath))
+	var tasks []transferTask
 
 	for _, file := range files {
 		fileName := file.Name()
 		absFilePath := path.Join(localFilePath, fileName)
+
+		if options.Ignore.Match(path.Join(rel, fileName), file.IsDir()) {
+			continue
+		}
+
 		if file.IsDir() {
-			if err = c.uploadDir(absFilePath, remoteDir); err != nil {
-				return err
+			dirTasks, err := c.uploadDir(absFilePath, path.Join(remoteDir, fileName), path.Join(rel, fileName), options)
+
+			if err != nil {
+				return nil, err
 			}
+
+			tasks = append(tasks, dirTasks...)
 		} else {
-			if err := c.uploadFile(absFilePath, remoteDir); err != nil {
-				return err
-			}
+			tasks = append(tasks, transferTask{source: absFilePath, destination: path.Join(remoteDir, fileName), size: file.Size(), options: options})
 		}
 	}
 
-	return nil
+	return tasks, nil
 }
 
-func (c *Client) Upload(localFilePath string, remoteDir string) error {
-	localStat, err := os.Stat(localFilePath)
+func (c *Client) Upload(localFilePath string, remoteDir string, options TransferOptions) error {
+	return c.UploadFiles([]TransferFile{{Source: localFilePath, Destination: remoteDir, Options: options}}, 1)
+}
 
-	if err != nil {
-		return err
-	}
+// Upload the files. the files are uploaded by the workers concurrently if workers is greater than 1
+func (c *Client) UploadFiles(files []TransferFile, workers int) error {
+	var tasks []transferTask
 
-	if localStat.IsDir() {
-		return c.uploadDir(localFilePath, remoteDir)
-	} else {
-		return c.uploadFile(localFilePath, remoteDir)
+	for _, file := range files {
+		stat := os.Stat
+
+		// the link itself is uploaded
+		if file.Options.Preserve.Links {
+			stat = os.Lstat
+		}
+
+		localStat, err := stat(file.Source)
+
+		if err != nil {
+			return err
+		}
+
+		// the archive can not be extracted with a new name
+		if file.Options.Archive && file.Name == "" {
+			if c.hasCommand("tar") {
+				if err := c.uploadArchive(file.Source, file.Destination, file.Options); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			fmt.Println(color.YellowString("`tar` is not found at remote server, upload '%s' by SFTP", file.Source))
+		}
+
+		if localStat.IsDir() {
+			dirTasks, err := c.uploadDir(file.Source, file.destinationPath(), "", file.Options)
+
+			if err != nil {
+				return err
+			}
+
+			tasks = append(tasks, dirTasks...)
+		} else {
+			tasks = append(tasks, transferTask{source: file.Source, destination: file.destinationPath(), size: localStat.Size(), options: file.Options})
+		}
 	}
+
+	return runTransferTasks(tasks, workers, c.uploadFile)
 }
 
 func (c *Client) Copy(sourceFilepath string, destinationFilepath string) error {
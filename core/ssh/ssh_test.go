@@ -0,0 +1,187 @@
+package ssh
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/axetroy/s4/core/glob"
+)
+
+func TestUploadDir(t *testing.T) {
+	c, dir, cleanup := newTestClient(t)
+	defer cleanup()
+
+	writeTestFiles(t, filepath.Join(dir, "dist"), map[string]string{
+		"index.html":              "index",
+		"js/app.js":               "app",
+		"js/app.js.map":           "map",
+		"node_modules/a/index.js": "a",
+		"packages/node_modules/b": "b",
+	})
+
+	options := TransferOptions{Ignore: glob.NewIgnore([]string{"node_modules", "*.map"})}
+
+	if err := c.Upload(filepath.Join(dir, "dist"), filepath.Join(dir, "remote"), options); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+
+	var files []string
+
+	_ = filepath.Walk(filepath.Join(dir, "remote"), func(filePath string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			rel, _ := filepath.Rel(filepath.Join(dir, "remote"), filePath)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return err
+	})
+
+	if want := []string{"dist/index.html", "dist/js/app.js"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("Upload() files = %v, want %v", files, want)
+	}
+}
+
+func TestDownloadDir(t *testing.T) {
+	c, dir, cleanup := newTestClient(t)
+	defer cleanup()
+
+	writeTestFiles(t, filepath.Join(dir, "remote"), map[string]string{"a/b/c.txt": "c", "a/b/c.log": "log"})
+
+	options := TransferOptions{Ignore: glob.NewIgnore([]string{"*.log"})}
+
+	if err := c.Download(filepath.Join(dir, "remote"), filepath.Join(dir, "local"), options); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "local", "remote", "a", "b", "c.txt")); err != nil || string(b) != "c" {
+		t.Errorf("Download() content = %q, %v", b, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "local", "remote", "a", "b", "c.log")); !os.IsNotExist(err) {
+		t.Errorf("Download() should skip the ignored file")
+	}
+
+	if err := c.Download(filepath.Join(dir, "not-exist"), filepath.Join(dir, "local"), TransferOptions{}); err == nil {
+		t.Errorf("Download() expect error")
+	}
+}
+
+func TestGlob(t *testing.T) {
+	c, dir, cleanup := newTestClient(t)
+	defer cleanup()
+
+	writeTestFiles(t, dir, map[string]string{"app.log": "", "nginx/error.log": "", "nginx/access.log": "", "app.txt": ""})
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{pattern: "*.log", want: []string{"app.log"}},
+		{pattern: "**/*.log", want: []string{"app.log", "nginx/access.log", "nginx/error.log"}},
+		{pattern: "not-exist/**", want: nil},
+		{pattern: "app.txt", want: []string{"app.txt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			got, err := c.Glob(filepath.ToSlash(dir) + "/" + tt.pattern)
+
+			if err != nil {
+				t.Errorf("Glob() error = %v", err)
+				return
+			}
+
+			var want []string
+
+			for _, name := range tt.want {
+				want = append(want, filepath.ToSlash(dir)+"/"+name)
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Glob() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestUploadFileResume(t *testing.T) {
+	c, dir, cleanup := newTestClient(t)
+	defer cleanup()
+
+	localFile := filepath.Join(dir, "model.bin")
+	remoteFile := filepath.Join(dir, "remote", "model.bin")
+	tempFile := filepath.Join(dir, "remote", ".model.bin.s4tmp")
+
+	writeTestFiles(t, dir, map[string]string{"model.bin": "0123456789"})
+
+	old := time.Now().Add(-time.Hour)
+
+	if err := os.Chtimes(localFile, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	// the part of last upload. it is different from the local file, so it can tell the upload is resumed
+	writeTestFiles(t, filepath.Join(dir, "remote"), map[string]string{".model.bin.s4tmp": "abcd"})
+
+	if err := c.Upload(localFile, filepath.Join(dir, "remote"), TransferOptions{}); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+
+	if b, err := ioutil.ReadFile(remoteFile); err != nil || string(b) != "abcd456789" {
+		t.Errorf("Upload() content = %q, %v", b, err)
+	}
+
+	if _, err := os.Stat(tempFile); !os.IsNotExist(err) {
+		t.Errorf("Upload() should remove the temp file")
+	}
+
+	// the temp file is older than the local file. it is not a part of the local file
+	writeTestFiles(t, filepath.Join(dir, "remote"), map[string]string{".model.bin.s4tmp": "abcd"})
+
+	if err := os.Chtimes(tempFile, old.Add(-time.Hour), old.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Upload(localFile, filepath.Join(dir, "remote"), TransferOptions{}); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+
+	if b, err := ioutil.ReadFile(remoteFile); err != nil || string(b) != "0123456789" {
+		t.Errorf("Upload() content = %q, %v", b, err)
+	}
+}
+
+func TestDownloadFileResume(t *testing.T) {
+	c, dir, cleanup := newTestClient(t)
+	defer cleanup()
+
+	writeTestFiles(t, dir, map[string]string{"remote/model.bin": "0123456789", "local/.model.bin.s4tmp": "abcd"})
+
+	old := time.Now().Add(-time.Hour)
+
+	if err := os.Chtimes(filepath.Join(dir, "remote", "model.bin"), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Download(filepath.Join(dir, "remote", "model.bin"), filepath.Join(dir, "local"), TransferOptions{}); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "local", "model.bin")); err != nil || string(b) != "abcd456789" {
+		t.Errorf("Download() content = %q, %v", b, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "local", ".model.bin.s4tmp")); !os.IsNotExist(err) {
+		t.Errorf("Download() should remove the temp file")
+	}
+}
+
+func TestLocalTempPath(t *testing.T) {
+	got := localTempPath(filepath.Join("logs", "nginx", "access.log"))
+
+	if want := filepath.Join("logs", "nginx", ".access.log.s4tmp"); got != want {
+		t.Errorf("localTempPath() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,429 @@
+// Package sshtest provides an in-process SSH server for the tests of connection.
+package sshtest
+
+import (
+	"crypto/rand"
+	"io"
+	"net"
+	"os/exec"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+// Server accepts any password, serves SFTP with the local file system, runs the commands with local `sh`,
+// replies keepalive requests and supports `direct-tcpip` and `tcpip-forward`.
+type Server struct {
+	Host      string
+	Port      string
+	listener  net.Listener
+	config    *ssh.ServerConfig
+	lock      sync.Mutex
+	conns     map[*serverConn]struct{}
+	dropArmed bool // drop the connection when replying the next keepalive request
+}
+
+// the connection of a client and the resources opened by it
+type serverConn struct {
+	conn     *ssh.ServerConn
+	lock     sync.Mutex
+	sessions []ssh.Channel
+	forwards map[string]net.Listener // the listeners of `tcpip-forward`. the key is the address
+	done     chan struct{}           // closed when all the resources are released
+}
+
+type forwardPayload struct {
+	Addr string
+	Port uint32
+}
+
+type channelPayload struct {
+	Addr       string
+	Port       uint32
+	OriginAddr string
+	OriginPort uint32
+}
+
+// start the server at a random port of 127.0.0.1
+func NewServer() (*Server, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		return nil, err
+	}
+
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+
+	if err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+
+	s := &Server{
+		Host:     host,
+		Port:     port,
+		listener: listener,
+		config:   config,
+		conns:    map[*serverConn]struct{}{},
+	}
+
+	go s.serve()
+
+	return s, nil
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+
+		// listener have been closed
+		if err != nil {
+			return
+		}
+
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	sshConn, channels, requests, err := ssh.NewServerConn(conn, s.config)
+
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+
+	c := &serverConn{conn: sshConn, forwards: map[string]net.Listener{}, done: make(chan struct{})}
+
+	s.lock.Lock()
+	s.conns[c] = struct{}{}
+	s.lock.Unlock()
+
+	go s.handleRequests(c, requests)
+
+	for newChannel := range channels {
+		switch newChannel.ChannelType() {
+		case "session":
+			go c.handleSession(newChannel)
+		case "direct-tcpip":
+			go c.handleDirect(newChannel)
+		default:
+			_ = newChannel.Reject(ssh.UnknownChannelType, newChannel.ChannelType())
+		}
+	}
+
+	// the connection have been closed. release the listeners of it
+	c.lock.Lock()
+	for _, listener := range c.forwards {
+		_ = listener.Close()
+	}
+	c.forwards = nil
+	c.lock.Unlock()
+
+	s.lock.Lock()
+	delete(s.conns, c)
+	s.lock.Unlock()
+
+	close(c.done)
+}
+
+func (s *Server) handleRequests(c *serverConn, requests <-chan *ssh.Request) {
+	for req := range requests {
+		switch req.Type {
+		case "keepalive@openssh.com":
+			s.lock.Lock()
+			drop := s.dropArmed
+			s.dropArmed = false
+			s.lock.Unlock()
+
+			// close the sessions before replying, so the next request of client fails for sure
+			if drop {
+				c.closeSessions()
+			}
+
+			_ = req.Reply(true, nil)
+
+			if drop {
+				_ = c.conn.Close()
+				<-c.done
+			}
+		case "tcpip-forward":
+			var payload forwardPayload
+
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				_ = req.Reply(false, nil)
+				continue
+			}
+
+			port, err := c.listenForward(payload)
+
+			if err != nil {
+				_ = req.Reply(false, nil)
+				continue
+			}
+
+			_ = req.Reply(true, ssh.Marshal(struct{ Port uint32 }{port}))
+		case "cancel-tcpip-forward":
+			var payload forwardPayload
+
+			if err := ssh.Unmarshal(req.Payload, &payload); err == nil {
+				c.cancelForward(payload)
+			}
+
+			_ = req.Reply(true, nil)
+		default:
+			if req.WantReply {
+				_ = req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+// the `sftp` subsystem and `exec` are supported
+func (c *serverConn) handleSession(newChannel ssh.NewChannel) {
+	channel, requests, err := newChannel.Accept()
+
+	if err != nil {
+		return
+	}
+
+	c.lock.Lock()
+	c.sessions = append(c.sessions, channel)
+	c.lock.Unlock()
+
+	for req := range requests {
+		var payload struct{ Name string }
+
+		if req.Type == "exec" && ssh.Unmarshal(req.Payload, &payload) == nil {
+			_ = req.Reply(true, nil)
+
+			go run(channel, payload.Name)
+
+			continue
+		}
+
+		if req.Type != "subsystem" || ssh.Unmarshal(req.Payload, &payload) != nil || payload.Name != "sftp" {
+			if req.WantReply {
+				_ = req.Reply(false, nil)
+			}
+			continue
+		}
+
+		_ = req.Reply(true, nil)
+
+		go func() {
+			if server, err := sftp.NewServer(channel); err == nil {
+				_ = server.Serve()
+			}
+
+			_ = channel.Close()
+		}()
+	}
+}
+
+// run the command with local `sh` and send the exit status
+func run(channel ssh.Channel, command string) {
+	cmd := exec.Command("sh", "-c", command)
+
+	cmd.Stdin = channel
+	cmd.Stdout = channel
+	cmd.Stderr = channel.Stderr()
+
+	var status uint32
+
+	if err := cmd.Run(); err != nil {
+		status = 1
+
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			status = uint32(exitErr.ExitCode())
+		}
+	}
+
+	_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+
+	_ = channel.Close()
+}
+
+func (c *serverConn) handleDirect(newChannel ssh.NewChannel) {
+	var payload channelPayload
+
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &payload); err != nil {
+		_ = newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(payload.Addr, strconv.Itoa(int(payload.Port))))
+
+	if err != nil {
+		_ = newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	channel, requests, err := newChannel.Accept()
+
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+
+	go ssh.DiscardRequests(requests)
+
+	pipe(conn, channel)
+}
+
+func (c *serverConn) listenForward(payload forwardPayload) (uint32, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort(payload.Addr, strconv.Itoa(int(payload.Port))))
+
+	if err != nil {
+		return 0, err
+	}
+
+	port := uint32(listener.Addr().(*net.TCPAddr).Port)
+
+	c.lock.Lock()
+
+	// the connection have been closed
+	if c.forwards == nil {
+		c.lock.Unlock()
+		_ = listener.Close()
+		return 0, io.EOF
+	}
+
+	c.forwards[net.JoinHostPort(payload.Addr, strconv.Itoa(int(port)))] = listener
+	c.lock.Unlock()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+
+			go c.forward(conn, payload.Addr, port)
+		}
+	}()
+
+	return port, nil
+}
+
+func (c *serverConn) cancelForward(payload forwardPayload) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	addr := net.JoinHostPort(payload.Addr, strconv.Itoa(int(payload.Port)))
+
+	if listener, ok := c.forwards[addr]; ok {
+		_ = listener.Close()
+		delete(c.forwards, addr)
+	}
+}
+
+// forward the connection accepted by `tcpip-forward` listener to the client
+func (c *serverConn) forward(conn net.Conn, addr string, port uint32) {
+	origin := conn.RemoteAddr().(*net.TCPAddr)
+
+	channel, requests, err := c.conn.OpenChannel("forwarded-tcpip", ssh.Marshal(channelPayload{
+		Addr:       addr,
+		Port:       port,
+		OriginAddr: origin.IP.String(),
+		OriginPort: uint32(origin.Port),
+	}))
+
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+
+	go ssh.DiscardRequests(requests)
+
+	pipe(conn, channel)
+}
+
+func (c *serverConn) closeSessions() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, channel := range c.sessions {
+		_ = channel.Close()
+	}
+
+	c.sessions = nil
+}
+
+// copy the data between a and b until one side closed. then close both
+func pipe(a io.ReadWriteCloser, b io.ReadWriteCloser) {
+	done := make(chan struct{}, 2)
+
+	go func() {
+		_, _ = io.Copy(a, b)
+		done <- struct{}{}
+	}()
+
+	go func() {
+		_, _ = io.Copy(b, a)
+		done <- struct{}{}
+	}()
+
+	<-done
+
+	_ = a.Close()
+	_ = b.Close()
+}
+
+// Drop all the connections as the network is broken, and wait until the resources of them are released
+func (s *Server) Drop() {
+	s.lock.Lock()
+	conns := make([]*serverConn, 0, len(s.conns))
+
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.lock.Unlock()
+
+	for _, c := range conns {
+		_ = c.conn.Close()
+		<-c.done
+	}
+}
+
+// Drop the connection when replying the next keepalive request.
+// The client sees the connection alive, then its next request fails
+func (s *Server) DropAfterKeepAlive() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.dropArmed = true
+}
+
+// Close the server and all the connections
+func (s *Server) Close() error {
+	err := s.listener.Close()
+
+	s.Drop()
+
+	return err
+}
@@ -0,0 +1,619 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// the max number of files to hash in one `sha256sum` command
+const checksumBatchSize = 200
+
+// the exit code of shell if the command is not found
+const commandNotFoundCode = 127
+
+type SyncOptions struct {
+	Delete   bool // remove the files in destination which do not exist in source
+	Checksum bool // compare the files by SHA-256 instead of size and modification time
+	Append   bool // download the appended part only if the local file is smaller. it is for growing log files
+}
+
+// SyncError is the error of syncing one file
+type SyncError struct {
+	File string
+	Err  error
+}
+
+func (e SyncError) Error() string {
+	return fmt.Sprintf("%s: %s", e.File, e.Err)
+}
+
+// SyncResult is the relative paths of files which have been synced
+type SyncResult struct {
+	Added   []string
+	Changed []string
+	Removed []string
+	Failed  []SyncError
+}
+
+// the files and dirs under a dir. the key is the relative path separated by `/`
+type syncTree struct {
+	files map[string]os.FileInfo
+	dirs  map[string]os.FileInfo
+}
+
+func newSyncTree() syncTree {
+	return syncTree{files: map[string]os.FileInfo{}, dirs: map[string]os.FileInfo{}}
+}
+
+// what to do to sync the source tree to the destination tree
+type syncPlan struct {
+	removed []string        // the files and dirs to remove from destination. the files in the dir come first
+	dirs    []string        // the dirs to create in destination
+	files   []string        // the files to transfer
+	exist   map[string]bool // whether the file to transfer exist in destination
+}
+
+// compare the trees. the files with same size are passed to equal if checksum is required
+func newSyncPlan(src syncTree, dst syncTree, options SyncOptions, equal func(files []string) (map[string]bool, error)) (syncPlan, error) {
+	plan := syncPlan{exist: map[string]bool{}}
+
+	if options.Delete {
+		for rel := range dst.files {
+			if _, ok := src.files[rel]; !ok {
+				plan.removed = append(plan.removed, rel)
+			}
+		}
+
+		for rel := range dst.dirs {
+			if _, ok := src.dirs[rel]; !ok {
+				plan.removed = append(plan.removed, rel)
+			}
+		}
+
+		sort.Sort(sort.Reverse(sort.StringSlice(plan.removed)))
+	}
+
+	for rel := range src.dirs {
+		if _, ok := dst.dirs[rel]; !ok {
+			plan.dirs = append(plan.dirs, rel)
+		}
+	}
+
+	sort.Strings(plan.dirs)
+
+	var files []string
+	var sameSize []string
+
+	for rel := range src.files {
+		files = append(files, rel)
+	}
+
+	sort.Strings(files)
+
+	for _, rel := range files {
+		srcStat := src.files[rel]
+		dstStat, ok := dst.files[rel]
+
+		switch {
+		case !ok:
+			plan.files = append(plan.files, rel)
+		case dstStat.Size() != srcStat.Size():
+			plan.files = append(plan.files, rel)
+			plan.exist[rel] = true
+		case options.Checksum:
+			sameSize = append(sameSize, rel)
+		// the modification time of SFTP is in seconds
+		case dstStat.ModTime().Unix() != srcStat.ModTime().Unix():
+			plan.files = append(plan.files, rel)
+			plan.exist[rel] = true
+		}
+	}
+
+	if len(sameSize) != 0 {
+		result, err := equal(sameSize)
+
+		if err != nil {
+			return plan, err
+		}
+
+		for _, rel := range sameSize {
+			if !result[rel] {
+				plan.files = append(plan.files, rel)
+				plan.exist[rel] = true
+			}
+		}
+
+		sort.Strings(plan.files)
+	}
+
+	return plan, nil
+}
+
+// walk the local dir. the symbolic links to file are followed, others are ignored
+func localSyncTree(root string) (syncTree, error) {
+	tree := newSyncTree()
+
+	err := filepath.Walk(root, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, filePath)
+
+		if err != nil {
+			return err
+		}
+
+		if rel == "." {
+			return nil
+		}
+
+		rel = filepath.ToSlash(rel)
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			if info, err = os.Stat(filePath); err != nil || !info.Mode().IsRegular() {
+				return nil
+			}
+		}
+
+		if info.IsDir() {
+			tree.dirs[rel] = info
+		} else if info.Mode().IsRegular() {
+			tree.files[rel] = info
+		}
+
+		return nil
+	})
+
+	return tree, err
+}
+
+// walk the remote dir. symbolic links are not followed and treated as files
+func (c *Client) remoteSyncTree(root string) (syncTree, error) {
+	tree := newSyncTree()
+
+	walker := c.sftpClient.Walk(root)
+
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return tree, err
+		}
+
+		rel := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), root), "/")
+
+		if rel == "" {
+			continue
+		}
+
+		if walker.Stat().IsDir() {
+			tree.dirs[rel] = walker.Stat()
+		} else {
+			tree.files[rel] = walker.Stat()
+		}
+	}
+
+	return tree, nil
+}
+
+func localChecksum(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer file.Close()
+
+	hash := sha256.New()
+
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// compute the SHA-256 of remote files by `sha256sum`.
+// the files which the command fail to hash are read back through SFTP
+func (c *Client) remoteChecksums(files []string) (map[string]string, error) {
+	result := map[string]string{}
+
+	for start := 0; start < len(files) && c.sshClient != nil; start += checksumBatchSize {
+		end := start + checksumBatchSize
+
+		if end > len(files) {
+			end = len(files)
+		}
+
+		quoted := make([]string, 0, end-start)
+
+		for _, file := range files[start:end] {
+			quoted = append(quoted, shellQuote(file))
+		}
+
+		var stdout bytes.Buffer
+
+		// `sha256sum` exits with non-zero code if any file fails, the others are still printed
+		if err := c.Stream("sha256sum -- "+strings.Join(quoted, " "), Options{}, nil, &stdout, ioutil.Discard); err != nil {
+			// the connection fails or `sha256sum` does not exist
+			if code, ok := ExitCode(err); !ok || code == commandNotFoundCode {
+				break
+			}
+		}
+
+		scanner := bufio.NewScanner(&stdout)
+
+		for scanner.Scan() {
+			// the line starts with `\` if the name is escaped
+			if fields := strings.SplitN(scanner.Text(), "  ", 2); len(fields) == 2 && !strings.HasPrefix(fields[0], "\\") {
+				result[fields[1]] = fields[0]
+			}
+		}
+	}
+
+	for _, file := range files {
+		if _, ok := result[file]; ok {
+			continue
+		}
+
+		sum, err := c.sftpChecksum(file)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result[file] = sum
+	}
+
+	return result, nil
+}
+
+// compute the SHA-256 of remote file by reading it back through SFTP
+func (c *Client) sftpChecksum(remoteFilePath string) (string, error) {
+	file, err := c.sftpClient.Open(remoteFilePath)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer file.Close()
+
+	hash := sha256.New()
+
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// compare the local files and remote files by SHA-256
+func (c *Client) equalChecksum(localDir string, remoteDir string) func(files []string) (map[string]bool, error) {
+	return func(files []string) (map[string]bool, error) {
+		remoteFiles := make([]string, 0, len(files))
+
+		for _, rel := range files {
+			remoteFiles = append(remoteFiles, path.Join(remoteDir, rel))
+		}
+
+		sums, err := c.remoteChecksums(remoteFiles)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result := map[string]bool{}
+
+		for _, rel := range files {
+			sum, err := localChecksum(filepath.Join(localDir, filepath.FromSlash(rel)))
+
+			if err != nil {
+				return nil, err
+			}
+
+			result[rel] = sum == sums[path.Join(remoteDir, rel)]
+		}
+
+		return result, nil
+	}
+}
+
+// run the plan. the errors of each file are collected in the result
+func (c *Client) runSyncPlan(plan syncPlan, remove func(rel string) error, mkdir func(rel string) error, transfer func(rel string) error) (SyncResult, error) {
+	var result SyncResult
+
+	for _, rel := range plan.removed {
+		if err := remove(rel); err != nil {
+			result.Failed = append(result.Failed, SyncError{File: rel, Err: err})
+		}
+	}
+
+	for _, rel := range plan.dirs {
+		if err := mkdir(rel); err != nil {
+			result.Failed = append(result.Failed, SyncError{File: rel, Err: err})
+		}
+	}
+
+	for _, rel := range plan.files {
+		if c.Interrupted() {
+			return result, ErrInterrupted
+		}
+
+		if err := transfer(rel); err != nil {
+			if err == ErrInterrupted {
+				return result, err
+			}
+
+			result.Failed = append(result.Failed, SyncError{File: rel, Err: err})
+		} else if plan.exist[rel] {
+			result.Changed = append(result.Changed, rel)
+		} else {
+			result.Added = append(result.Added, rel)
+		}
+	}
+
+	if len(result.Failed) != 0 {
+		return result, fmt.Errorf("failed to sync %d files", len(result.Failed))
+	}
+
+	return result, nil
+}
+
+// Sync the content of local dir to the remote dir. only the added or changed files are uploaded
+func (c *Client) Sync(localDir string, remoteDir string, options SyncOptions) (SyncResult, error) {
+	var result SyncResult
+
+	remoteDir = path.Clean(remoteDir)
+
+	if stat, err := os.Stat(localDir); err != nil {
+		return result, err
+	} else if !stat.IsDir() {
+		return result, fmt.Errorf("'%s' is not a dir", localDir)
+	}
+
+	local, err := localSyncTree(localDir)
+
+	if err != nil {
+		return result, err
+	}
+
+	remote := newSyncTree()
+
+	if stat, err := c.sftpClient.Stat(remoteDir); err == nil {
+		if !stat.IsDir() {
+			return result, fmt.Errorf("'%s' is not a dir", remoteDir)
+		}
+
+		if remote, err = c.remoteSyncTree(remoteDir); err != nil {
+			return result, err
+		}
+	} else if !os.IsNotExist(err) {
+		return result, err
+	} else if err := c.sftpClient.MkdirAll(remoteDir); err != nil {
+		return result, err
+	}
+
+	plan, err := newSyncPlan(local, remote, options, c.equalChecksum(localDir, remoteDir))
+
+	if err != nil {
+		return result, err
+	}
+
+	result, err = c.runSyncPlan(plan, func(rel string) error {
+		remoteFilePath := path.Join(remoteDir, rel)
+
+		if _, ok := remote.dirs[rel]; ok {
+			return c.sftpClient.RemoveDirectory(remoteFilePath)
+		}
+
+		return c.sftpClient.Remove(remoteFilePath)
+	}, func(rel string) error {
+		return c.sftpClient.MkdirAll(path.Join(remoteDir, rel))
+	}, func(rel string) error {
+		localFilePath := filepath.Join(localDir, filepath.FromSlash(rel))
+		remoteFilePath := path.Join(remoteDir, rel)
+
+		// keep the modification time so that the file is considered unchanged next time
+		return c.uploadFile(localFilePath, remoteFilePath, TransferOptions{Preserve: Preserve{Times: true}}, nil)
+	})
+
+	for _, rel := range plan.removed {
+		if _, ok := remote.files[rel]; ok && !result.failed(rel) {
+			result.Removed = append(result.Removed, rel)
+		}
+	}
+
+	sort.Strings(result.Removed)
+
+	return result, err
+}
+
+// SyncRemote sync the content of remote dir to the local dir. only the added or changed files are downloaded
+func (c *Client) SyncRemote(remoteDir string, localDir string, options SyncOptions) (SyncResult, error) {
+	var result SyncResult
+
+	remoteDir = path.Clean(remoteDir)
+
+	if stat, err := c.sftpClient.Stat(remoteDir); err != nil {
+		return result, err
+	} else if !stat.IsDir() {
+		return result, fmt.Errorf("'%s' is not a dir", remoteDir)
+	}
+
+	remote, err := c.remoteSyncTree(remoteDir)
+
+	if err != nil {
+		return result, err
+	}
+
+	local := newSyncTree()
+
+	if stat, err := os.Stat(localDir); err == nil {
+		if !stat.IsDir() {
+			return result, fmt.Errorf("'%s' is not a dir", localDir)
+		}
+
+		if local, err = localSyncTree(localDir); err != nil {
+			return result, err
+		}
+	} else if !os.IsNotExist(err) {
+		return result, err
+	} else if err := os.MkdirAll(localDir, 0755); err != nil {
+		return result, err
+	}
+
+	// the remote symbolic links to file are followed as the local ones. the local files of the same name as
+	// other links and special files are kept, otherwise DELETE removes them
+	var kept []string
+
+	for rel, stat := range remote.files {
+		if stat.Mode().IsRegular() {
+			continue
+		}
+
+		if stat.Mode()&os.ModeSymlink != 0 {
+			if target, err := c.sftpClient.Stat(path.Join(remoteDir, rel)); err == nil && target.Mode().IsRegular() {
+				remote.files[rel] = target
+				continue
+			}
+		}
+
+		delete(remote.files, rel)
+		kept = append(kept, rel)
+	}
+
+	plan, err := newSyncPlan(remote, local, options, c.equalChecksum(localDir, remoteDir))
+
+	if err != nil {
+		return result, err
+	}
+
+	plan.removed = excludeSyncFiles(plan.removed, kept)
+
+	result, err = c.runSyncPlan(plan, func(rel string) error {
+		return os.Remove(filepath.Join(localDir, filepath.FromSlash(rel)))
+	}, func(rel string) error {
+		return os.MkdirAll(filepath.Join(localDir, filepath.FromSlash(rel)), 0755)
+	}, func(rel string) error {
+		localFilePath := filepath.Join(localDir, filepath.FromSlash(rel))
+		remoteFilePath := path.Join(remoteDir, rel)
+		remoteStat := remote.files[rel]
+
+		// keep the modification time so that the file is considered unchanged next time
+		if localStat, ok := local.files[rel]; ok && options.Append && localStat.Size() < remoteStat.Size() {
+			if err := c.downloadAppend(remoteFilePath, localFilePath, localStat.Size()); err != nil {
+				return err
+			}
+
+			return preserveLocal(localFilePath, remoteStat, Preserve{Times: true})
+		}
+
+		return c.downloadFile(remoteFilePath, localFilePath, TransferOptions{Preserve: Preserve{Times: true}}, nil)
+	})
+
+	for _, rel := range plan.removed {
+		if _, ok := local.files[rel]; ok && !result.failed(rel) {
+			result.Removed = append(result.Removed, rel)
+		}
+	}
+
+	sort.Strings(result.Removed)
+
+	return result, err
+}
+
+// remove the files and the files in the dirs from the list
+func excludeSyncFiles(files []string, excluded []string) []string {
+	if len(excluded) == 0 {
+		return files
+	}
+
+	var result []string
+
+loop:
+	for _, file := range files {
+		for _, rel := range excluded {
+			if file == rel || strings.HasPrefix(file, rel+"/") {
+				continue loop
+			}
+		}
+
+		result = append(result, file)
+	}
+
+	return result
+}
+
+// download the part of remote file after offset and append it to the local file
+func (c *Client) downloadAppend(remoteFilePath string, localFilePath string, offset int64) error {
+	remoteFile, err := c.sftpClient.Open(remoteFilePath)
+
+	if err != nil {
+		return err
+	}
+
+	defer remoteFile.Close()
+
+	remoteFileStat, err := remoteFile.Stat()
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := remoteFile.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	localFile, err := os.OpenFile(localFilePath, os.O_WRONLY|os.O_APPEND, 0)
+
+	if err != nil {
+		return err
+	}
+
+	defer localFile.Close()
+
+	bar := newProgressBar(localFilePath, remoteFileStat.Size()-offset)
+
+	barReader := bar.NewProxyReader(interruptReader{client: c, reader: remoteFile})
+
+	if _, err := io.Copy(localFile, barReader); err != nil {
+		// remove the half-appended part
+		_ = localFile.Truncate(offset)
+		return err
+	}
+
+	bar.Finish()
+
+	return nil
+}
+
+func (r SyncResult) failed(file string) bool {
+	for _, e := range r.Failed {
+		if e.File == file {
+			return true
+		}
+	}
+
+	return false
+}
+
+// String returns the summary of result
+func (r SyncResult) String() string {
+	summary := fmt.Sprintf("%d added, %d changed, %d removed", len(r.Added), len(r.Changed), len(r.Removed))
+
+	if len(r.Failed) != 0 {
+		summary += fmt.Sprintf(", %d failed", len(r.Failed))
+	}
+
+	return summary
+}
@@ -0,0 +1,235 @@
+package ssh
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSync(t *testing.T) {
+	c, dir, cleanup := newTestClient(t)
+	defer cleanup()
+
+	localDir := filepath.Join(dir, "local")
+	remoteDir := filepath.Join(dir, "remote")
+
+	writeTestFiles(t, localDir, map[string]string{
+		"index.html":    "index",
+		"js/app.js":     "app",
+		"css/style.css": "style",
+	})
+
+	result, err := c.Sync(localDir, remoteDir, SyncOptions{})
+
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	if want := []string{"css/style.css", "index.html", "js/app.js"}; !reflect.DeepEqual(result.Added, want) {
+		t.Errorf("Sync() added = %v, want %v", result.Added, want)
+	}
+
+	// nothing changed
+	if result, err = c.Sync(localDir, remoteDir, SyncOptions{}); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	if result.String() != "0 added, 0 changed, 0 removed" {
+		t.Errorf("Sync() = %s, want nothing changed", result)
+	}
+
+	writeTestFiles(t, localDir, map[string]string{
+		"js/app.js":    "app v2",
+		"js/vendor.js": "vendor",
+	})
+
+	if err := os.RemoveAll(filepath.Join(localDir, "css")); err != nil {
+		t.Fatal(err)
+	}
+
+	if result, err = c.Sync(localDir, remoteDir, SyncOptions{}); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	if want := (SyncResult{Added: []string{"js/vendor.js"}, Changed: []string{"js/app.js"}}); !reflect.DeepEqual(result, want) {
+		t.Errorf("Sync() = %+v, want %+v", result, want)
+	}
+
+	if _, err := os.Stat(filepath.Join(remoteDir, "css", "style.css")); err != nil {
+		t.Errorf("Sync() should not remove the file without DELETE: %v", err)
+	}
+
+	if result, err = c.Sync(localDir, remoteDir, SyncOptions{Delete: true}); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	if want := []string{"css/style.css"}; !reflect.DeepEqual(result.Removed, want) {
+		t.Errorf("Sync() removed = %v, want %v", result.Removed, want)
+	}
+
+	if _, err := os.Stat(filepath.Join(remoteDir, "css")); !os.IsNotExist(err) {
+		t.Errorf("Sync() should remove the dir which does not exist locally")
+	}
+
+	if b, err := ioutil.ReadFile(filepath.Join(remoteDir, "js", "app.js")); err != nil || string(b) != "app v2" {
+		t.Errorf("Sync() content = %q, %v", b, err)
+	}
+}
+
+func TestSyncWithChecksum(t *testing.T) {
+	c, dir, cleanup := newTestClient(t)
+	defer cleanup()
+
+	localDir := filepath.Join(dir, "local")
+	remoteDir := filepath.Join(dir, "remote")
+
+	writeTestFiles(t, localDir, map[string]string{"a.txt": "aaa", "b.txt": "bbb"})
+	writeTestFiles(t, remoteDir, map[string]string{"a.txt": "aaa", "b.txt": "ccc"})
+
+	// the modification time is different but the content is the same
+	old := time.Now().Add(-time.Hour)
+
+	if err := os.Chtimes(filepath.Join(remoteDir, "a.txt"), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := c.Sync(localDir, remoteDir, SyncOptions{Checksum: true})
+
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	if want := (SyncResult{Changed: []string{"b.txt"}}); !reflect.DeepEqual(result, want) {
+		t.Errorf("Sync() = %+v, want %+v", result, want)
+	}
+}
+
+func TestSyncRemote(t *testing.T) {
+	c, dir, cleanup := newTestClient(t)
+	defer cleanup()
+
+	localDir := filepath.Join(dir, "local")
+	remoteDir := filepath.Join(dir, "remote")
+
+	writeTestFiles(t, remoteDir, map[string]string{
+		"app.log":         "line 1\n",
+		"nginx/error.log": "error 1\n",
+	})
+
+	result, err := c.SyncRemote(remoteDir, localDir, SyncOptions{Append: true})
+
+	if err != nil {
+		t.Fatalf("SyncRemote() error = %v", err)
+	}
+
+	if want := []string{"app.log", "nginx/error.log"}; !reflect.DeepEqual(result.Added, want) {
+		t.Errorf("SyncRemote() added = %v, want %v", result.Added, want)
+	}
+
+	// the local file is modified, so that it can tell the file is appended instead of downloaded
+	writeTestFiles(t, localDir, map[string]string{"app.log": "LINE 1\n"})
+	writeTestFiles(t, remoteDir, map[string]string{"app.log": "line 1\nline 2\n"})
+
+	if result, err = c.SyncRemote(remoteDir, localDir, SyncOptions{Append: true}); err != nil {
+		t.Fatalf("SyncRemote() error = %v", err)
+	}
+
+	if want := (SyncResult{Changed: []string{"app.log"}}); !reflect.DeepEqual(result, want) {
+		t.Errorf("SyncRemote() = %+v, want %+v", result, want)
+	}
+
+	if b, err := ioutil.ReadFile(filepath.Join(localDir, "app.log")); err != nil || string(b) != "LINE 1\nline 2\n" {
+		t.Errorf("SyncRemote() content = %q, %v", b, err)
+	}
+
+	// the local dir can not be created, the file fails but the others are synced
+	if err := os.RemoveAll(filepath.Join(localDir, "nginx")); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFiles(t, localDir, map[string]string{"nginx": "not a dir"})
+	writeTestFiles(t, remoteDir, map[string]string{"app.log": "line 1\nline 2\nline 3\n"})
+
+	result, err = c.SyncRemote(remoteDir, localDir, SyncOptions{})
+
+	if err == nil {
+		t.Errorf("SyncRemote() expect error")
+	}
+
+	if len(result.Failed) != 2 || result.Failed[0].File != "nginx" || result.Failed[1].File != "nginx/error.log" {
+		t.Errorf("SyncRemote() failed = %v, want nginx and nginx/error.log", result.Failed)
+	}
+
+	if want := []string{"app.log"}; !reflect.DeepEqual(result.Changed, want) {
+		t.Errorf("SyncRemote() changed = %v, want %v", result.Changed, want)
+	}
+}
+
+func TestSyncRemoteLinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic link requires privilege on windows")
+	}
+
+	c, dir, cleanup := newTestClient(t)
+	defer cleanup()
+
+	localDir := filepath.Join(dir, "local")
+	remoteDir := filepath.Join(dir, "remote")
+
+	writeTestFiles(t, filepath.Join(dir, "shared"), map[string]string{"config.yml": "port: 80", "logs/app.log": "line 1"})
+	writeTestFiles(t, remoteDir, map[string]string{"index.html": "index"})
+
+	links := map[string]string{
+		"config.yml": "../shared/config.yml",
+		"logs":       "../shared/logs",
+		"broken":     "../shared/not-exist",
+	}
+
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(remoteDir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeTestFiles(t, localDir, map[string]string{
+		"config.yml":   "port: 8080",
+		"logs/old.log": "old",
+		"broken":       "local",
+		"removed.html": "removed",
+	})
+
+	result, err := c.SyncRemote(filepath.ToSlash(remoteDir), localDir, SyncOptions{Delete: true})
+
+	if err != nil {
+		t.Fatalf("SyncRemote() error = %v", err)
+	}
+
+	if want := []string{"removed.html"}; !reflect.DeepEqual(result.Removed, want) {
+		t.Errorf("SyncRemote() removed = %v, want %v", result.Removed, want)
+	}
+
+	// the link to file is followed, the local files of the other links are kept
+	for name, want := range map[string]string{"config.yml": "port: 80", "logs/old.log": "old", "broken": "local"} {
+		if b, err := ioutil.ReadFile(filepath.Join(localDir, filepath.FromSlash(name))); err != nil || string(b) != want {
+			t.Errorf("SyncRemote() content of %s = %q, %v, want %q", name, b, err, want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package ssh
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/cheggaaa/pb/v3"
+)
+
+// the file to transfer by a worker
+type transferTask struct {
+	source      string
+	destination string // the path to transfer to
+	size        int64
+	options     TransferOptions
+}
+
+// transfer the file to the destination. the progress is shown in bar if it is not nil
+type transferFunc func(source string, destination string, options TransferOptions, bar *pb.ProgressBar) error
+
+// run the tasks one by one with a progress bar for each file, or by the workers concurrently with one progress bar for all files.
+// no more task is started after a task fails
+func runTransferTasks(tasks []transferTask, workers int, transfer transferFunc) error {
+	if workers <= 1 || len(tasks) <= 1 {
+		for _, task := range tasks {
+			if err := transfer(task.source, task.destination, task.options, nil); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if workers > len(tasks) {
+		workers = len(tasks)
+	}
+
+	var size int64
+
+	for _, task := range tasks {
+		size += task.size
+	}
+
+	bar := newProgressBar(fmt.Sprintf("%d files", len(tasks)), size)
+
+	var (
+		wg      sync.WaitGroup
+		once    sync.Once
+		err     error
+		queue   = make(chan transferTask)
+		aborted = make(chan struct{}) // closed when a task fails
+	)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for task := range queue {
+				if taskErr := transfer(task.source, task.destination, task.options, bar); taskErr != nil {
+					once.Do(func() {
+						err = taskErr
+						close(aborted)
+					})
+				}
+			}
+		}()
+	}
+
+dispatch:
+	for _, task := range tasks {
+		select {
+		case queue <- task:
+		case <-aborted:
+			break dispatch
+		}
+	}
+
+	close(queue)
+
+	wg.Wait()
+
+	// stop refreshing the bar even if it fails, the other workers may have transferred a part of files
+	bar.Finish()
+
+	return err
+}
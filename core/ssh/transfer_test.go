@@ -0,0 +1,131 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cheggaaa/pb/v3"
+)
+
+func TestRunTransferTasks(t *testing.T) {
+	var tasks []transferTask
+
+	for i := 0; i < 20; i++ {
+		tasks = append(tasks, transferTask{source: fmt.Sprintf("file%d", i), size: 1})
+	}
+
+	var running, maxRunning int32
+	var mutex sync.Mutex
+	var transferred []string
+
+	err := runTransferTasks(tasks, 4, func(source string, dir string, options TransferOptions, bar *pb.ProgressBar) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+
+		mutex.Lock()
+		if n > maxRunning {
+			maxRunning = n
+		}
+		transferred = append(transferred, source)
+		mutex.Unlock()
+
+		if bar == nil {
+			return errors.New("the progress bar should be shared")
+		}
+
+		time.Sleep(time.Millisecond * 5)
+
+		bar.Add(1)
+
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("runTransferTasks() error = %v", err)
+	}
+
+	if len(transferred) != len(tasks) {
+		t.Errorf("runTransferTasks() transferred %d files, want %d", len(transferred), len(tasks))
+	}
+
+	if maxRunning < 2 || maxRunning > 4 {
+		t.Errorf("runTransferTasks() runs %d tasks concurrently, want 2-4", maxRunning)
+	}
+}
+
+func TestRunTransferTasksFail(t *testing.T) {
+	var tasks []transferTask
+
+	for i := 0; i < 100; i++ {
+		tasks = append(tasks, transferTask{source: fmt.Sprintf("file%d", i)})
+	}
+
+	var count int32
+
+	err := runTransferTasks(tasks, 2, func(source string, dir string, options TransferOptions, bar *pb.ProgressBar) error {
+		atomic.AddInt32(&count, 1)
+
+		if source == "file3" {
+			return errors.New("failed")
+		}
+
+		time.Sleep(time.Millisecond * 5)
+
+		return nil
+	})
+
+	if err == nil || err.Error() != "failed" {
+		t.Errorf("runTransferTasks() error = %v", err)
+	}
+
+	if count == int32(len(tasks)) {
+		t.Errorf("runTransferTasks() should stop after the task fails")
+	}
+}
+
+func TestUploadFilesParallel(t *testing.T) {
+	c, dir, cleanup := newTestClient(t)
+	defer cleanup()
+
+	files := map[string]string{}
+
+	for i := 0; i < 20; i++ {
+		files[fmt.Sprintf("dir%d/file%d.txt", i%3, i)] = fmt.Sprintf("content %d", i)
+	}
+
+	writeTestFiles(t, filepath.Join(dir, "dist"), files)
+	writeTestFiles(t, dir, map[string]string{"VERSION": "1.0.0"})
+
+	err := c.UploadFiles([]TransferFile{
+		{Source: filepath.Join(dir, "dist"), Destination: filepath.Join(dir, "remote")},
+		{Source: filepath.Join(dir, "VERSION"), Destination: filepath.Join(dir, "remote", "dist")},
+	}, 4)
+
+	if err != nil {
+		t.Fatalf("UploadFiles() error = %v", err)
+	}
+
+	files["VERSION"] = "1.0.0"
+
+	for name, content := range files {
+		if b, err := ioutil.ReadFile(filepath.Join(dir, "remote", "dist", filepath.FromSlash(name))); err != nil || string(b) != content {
+			t.Errorf("UploadFiles() content of '%s' = %q, %v", name, b, err)
+		}
+	}
+
+	if err := c.DownloadFiles([]TransferFile{{Source: filepath.Join(dir, "remote", "dist"), Destination: filepath.Join(dir, "local")}}, 4); err != nil {
+		t.Fatalf("DownloadFiles() error = %v", err)
+	}
+
+	for name, content := range files {
+		if b, err := ioutil.ReadFile(filepath.Join(dir, "local", "dist", filepath.FromSlash(name))); err != nil || string(b) != content {
+			t.Errorf("DownloadFiles() content of '%s' = %q, %v", name, b, err)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package ssh
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/fatih/color"
+)
+
+// Tunnel forward the connections accepted by listener to the target address
+type Tunnel struct {
+	listener net.Listener
+	addr     string // the address to listen. the remote tunnel listens it again after reconnecting
+	target   string
+	dial     func(addr string) (net.Conn, error)
+	onClose  func()
+	conns    map[net.Conn]struct{} // the forwarded connections which are closed with the tunnel
+	closed   bool
+	lock     sync.Mutex
+}
+
+func (t *Tunnel) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+
+		// listener have been closed
+		if err != nil {
+			return
+		}
+
+		go t.forward(conn)
+	}
+}
+
+// track the forwarded connection. it returns false if the tunnel have been closed
+func (t *Tunnel) track(conn net.Conn) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.closed {
+		return false
+	}
+
+	if t.conns == nil {
+		t.conns = map[net.Conn]struct{}{}
+	}
+
+	t.conns[conn] = struct{}{}
+
+	return true
+}
+
+func (t *Tunnel) untrack(conn net.Conn) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	delete(t.conns, conn)
+}
+
+func (t *Tunnel) forward(conn net.Conn) {
+	defer conn.Close()
+
+	if !t.track(conn) {
+		return
+	}
+
+	defer t.untrack(conn)
+
+	targetConn, err := t.dial(t.target)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, color.RedString(fmt.Sprintf("Tunnel to `%s` fail: %s", t.target, err)))
+		return
+	}
+
+	defer targetConn.Close()
+
+	if !t.track(targetConn) {
+		return
+	}
+
+	defer t.untrack(targetConn)
+
+	done := make(chan struct{}, 2)
+
+	go func() {
+		_, _ = io.Copy(targetConn, conn)
+		done <- struct{}{}
+	}()
+
+	go func() {
+		_, _ = io.Copy(conn, targetConn)
+		done <- struct{}{}
+	}()
+
+	// one side closed. then close both
+	<-done
+}
+
+// Close the tunnel and the connections forwarded by it
+func (t *Tunnel) Close() error {
+	if t.onClose != nil {
+		t.onClose()
+	}
+
+	err := t.listener.Close()
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.closed = true
+
+	for conn := range t.conns {
+		_ = conn.Close()
+	}
+
+	t.conns = nil
+
+	return err
+}
+
+// forward the local address to the address at remote server
+func (c *Client) LocalForward(localAddr string, remoteAddr string) (*Tunnel, error) {
+	listener, err := net.Listen("tcp", localAddr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tunnel := &Tunnel{
+		listener: listener,
+		addr:     localAddr,
+		target:   remoteAddr,
+		dial: func(addr string) (net.Conn, error) {
+			return c.sshClient.Dial("tcp", addr)
+		},
+	}
+
+	go tunnel.serve(listener)
+
+	return tunnel, nil
+}
+
+// forward the address at remote server to the local address
+func (c *Client) RemoteForward(remoteAddr string, localAddr string) (*Tunnel, error) {
+	tunnel := &Tunnel{
+		addr:   remoteAddr,
+		target: localAddr,
+		dial: func(addr string) (net.Conn, error) {
+			return net.Dial("tcp", addr)
+		},
+	}
+
+	if err := c.listenRemote(tunnel); err != nil {
+		return nil, err
+	}
+
+	if c.remoteTunnels == nil {
+		c.remoteTunnels = map[*Tunnel]struct{}{}
+	}
+
+	c.remoteTunnels[tunnel] = struct{}{}
+
+	tunnel.onClose = func() {
+		delete(c.remoteTunnels, tunnel)
+	}
+
+	return tunnel, nil
+}
+
+// listen the address of remote tunnel at remote server with the current connection
+func (c *Client) listenRemote(tunnel *Tunnel) error {
+	listener, err := c.sshClient.Listen("tcp", tunnel.addr)
+
+	if err != nil {
+		return err
+	}
+
+	tunnel.listener = listener
+
+	go tunnel.serve(listener)
+
+	return nil
+}
+
+// the remote tunnels are bound to the closed connection. listen them again after reconnecting
+func (c *Client) relistenTunnels() error {
+	for tunnel := range c.remoteTunnels {
+		if err := c.listenRemote(tunnel); err != nil {
+			return fmt.Errorf("reopen tunnel `%s` fail: %s", tunnel.addr, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,196 @@
+package ssh
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/axetroy/s4/core/ssh/sshtest"
+)
+
+// connect to an in-process SSH server
+func newTestConnection(t *testing.T) (*Client, *sshtest.Server, func()) {
+	server, err := sshtest.NewServer()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	password := "test"
+
+	c := NewSSH()
+
+	if err := c.Connect(server.Host, server.Port, "test", &password, nil); err != nil {
+		_ = server.Close()
+		t.Fatal(err)
+	}
+
+	cleanup := func() {
+		_ = c.Disconnect()
+		_ = server.Close()
+	}
+
+	return c, server, cleanup
+}
+
+// start a TCP server which replies every line it receives
+func newEchoServer(t *testing.T) (string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return listener.Addr().String(), func() {
+		_ = listener.Close()
+	}
+}
+
+// get a free port of 127.0.0.1
+func freeAddr(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+
+	return listener.Addr().String()
+}
+
+// send a line through the connection and expect the same line back
+func checkEcho(t *testing.T, conn net.Conn, line string) {
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := conn.Write([]byte(line + "\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reply != line+"\n" {
+		t.Errorf("echo = %q, want %q", reply, line)
+	}
+}
+
+// the connection should be closed by the other side
+func checkClosed(t *testing.T, conn net.Conn) {
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		if e, ok := err.(net.Error); ok && e.Timeout() {
+			t.Error("the connection should be closed")
+		}
+	}
+}
+
+// the address should not accept connections any more. the listener is closed asynchronously at remote
+func checkNotListening(t *testing.T, addr string) {
+	deadline := time.Now().Add(5 * time.Second)
+
+	for time.Now().Before(deadline) {
+		conn, err := net.Dial("tcp", addr)
+
+		if err != nil {
+			return
+		}
+
+		_ = conn.Close()
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Errorf("`%s` should not be listened", addr)
+}
+
+func TestLocalForward(t *testing.T) {
+	c, _, cleanup := newTestConnection(t)
+	defer cleanup()
+
+	target, closeEcho := newEchoServer(t)
+	defer closeEcho()
+
+	tunnel, err := c.LocalForward("127.0.0.1:0", target)
+
+	if err != nil {
+		t.Fatalf("LocalForward() error = %v", err)
+	}
+
+	addr := tunnel.listener.Addr().String()
+
+	conn, err := net.Dial("tcp", addr)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	checkEcho(t, conn, "hello")
+
+	if err := tunnel.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	checkClosed(t, conn)
+	checkNotListening(t, addr)
+}
+
+func TestRemoteForward(t *testing.T) {
+	c, _, cleanup := newTestConnection(t)
+	defer cleanup()
+
+	target, closeEcho := newEchoServer(t)
+	defer closeEcho()
+
+	addr := freeAddr(t)
+
+	tunnel, err := c.RemoteForward(addr, target)
+
+	if err != nil {
+		t.Fatalf("RemoteForward() error = %v", err)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	checkEcho(t, conn, "hello")
+
+	if err := tunnel.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if len(c.remoteTunnels) != 0 {
+		t.Errorf("the closed tunnel should not be listened again after reconnecting")
+	}
+
+	checkClosed(t, conn)
+	checkNotListening(t, addr)
+}
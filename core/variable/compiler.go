@@ -6,7 +6,7 @@ import (
 )
 
 var (
-	expressionReg = regexp.MustCompile("\\{\\{\\s*(\\w+)\\s*\\}\\}")
+	expressionReg = regexp.MustCompile("\\{\\{\\s*([\\w\\.]+)\\s*\\}\\}")
 )
 
 func Compile(template string, varMap map[string]string) string {
@@ -16,9 +16,10 @@ func Compile(template string, varMap map[string]string) string {
 		key := matcher[1]
 		value := varMap[key]
 
-		replaceReg := regexp.MustCompile(fmt.Sprintf("\\{\\{\\s*(%s)\\s*\\}\\}", key))
+		replaceReg := regexp.MustCompile(fmt.Sprintf("\\{\\{\\s*(%s)\\s*\\}\\}", regexp.QuoteMeta(key)))
 
-		template = replaceReg.ReplaceAllString(template, value)
+		// the value may contain `$`. do not expand it
+		template = replaceReg.ReplaceAllLiteralString(template, value)
 	}
 
 	return template
@@ -49,6 +49,27 @@ func TestCompile(t *testing.T) {
 			},
 			want: "hello test, I am 18 years old. I live in ",
 		},
+		{
+			name: "variables with dot",
+			args: args{
+				template: "exit with {{ result.code }}: {{result.stdout}}",
+				varMap: map[string]string{
+					"result.code":   "1",
+					"result.stdout": "inactive",
+				},
+			},
+			want: "exit with 1: inactive",
+		},
+		{
+			name: "variables with dollar",
+			args: args{
+				template: "price: {{price}}",
+				varMap: map[string]string{
+					"price": "$1",
+				},
+			},
+			want: "price: $1",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -10,4 +10,5 @@ require (
 	github.com/pkg/sftp v1.11.0
 	github.com/urfave/cli/v2 v2.1.1
 	golang.org/x/crypto v0.0.0-20200109152110-61a87790db17
+	golang.org/x/sys v0.0.0-20191128015809-6d18c012aee9
 )
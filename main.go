@@ -3,8 +3,11 @@ package main
 import (
 	"log"
 	"os"
+	"time"
 
 	"github.com/axetroy/s4/core/command"
+	"github.com/axetroy/s4/core/host"
+	"github.com/axetroy/s4/core/runner"
 	"github.com/urfave/cli/v2"
 )
 
@@ -51,6 +54,20 @@ SOURCE CODE:
 			Usage:   "specify the s4 configuration file.",
 			Value:   ".s4", // default value
 		},
+		&cli.StringFlag{
+			Name:  "user",
+			Usage: "the default username to connect to server if not specified. default is `$USER`.",
+		},
+		&cli.DurationFlag{
+			Name:  "keepalive",
+			Usage: "the interval of sending keepalive request to server. 0 to disable.",
+			Value: time.Second * 30, // default value
+		},
+		&cli.IntFlag{
+			Name:  "transfer-workers",
+			Usage: "the number of files to transfer concurrently by UPLOAD and DOWNLOAD.",
+			Value: 1, // default value
+		},
 	}
 
 	app.Commands = []*cli.Command{
@@ -79,7 +96,11 @@ SOURCE CODE:
 
 	app.Action = func(c *cli.Context) error {
 		configFile := c.String("config")
-		return command.Default(configFile)
+		host.DefaultUsername = c.String("user")
+		return command.Default(configFile, runner.Options{
+			KeepAlive:       c.Duration("keepalive"),
+			TransferWorkers: c.Int("transfer-workers"),
+		})
 	}
 
 	if err := app.Run(os.Args); err != nil {